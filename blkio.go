@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	blkioPerDevice = flag.Bool("collector.blkio-per-device", false, "Export the block I/O bytes of each container per device. Adds a series per container and disk.")
+	sysfsRoot      = flag.String("collector.blkio-per-device.sysfs-root", "/sys", "Mount point of the host's sysfs, used to resolve device numbers to names.")
+)
+
+// blkioDevice is the block I/O of a container on one device.
+type blkioDevice struct {
+	major, minor uint64
+	read, write  uint64
+}
+
+// blkioPerDeviceBytes groups the read and write bytes of IoServiceBytesRecursive
+// by device, ordered by device number.
+func blkioPerDeviceBytes(entries []types.BlkioStatEntry) []blkioDevice {
+	devices := map[[2]uint64]*blkioDevice{}
+	for _, entry := range entries {
+		key := [2]uint64{entry.Major, entry.Minor}
+		device, ok := devices[key]
+		if !ok {
+			device = &blkioDevice{major: entry.Major, minor: entry.Minor}
+			devices[key] = device
+		}
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			device.read += entry.Value
+		case "write":
+			device.write += entry.Value
+		}
+	}
+
+	result := make([]blkioDevice, 0, len(devices))
+	for _, device := range devices {
+		result = append(result, *device)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].major != result[j].major {
+			return result[i].major < result[j].major
+		}
+		return result[i].minor < result[j].minor
+	})
+	return result
+}
+
+// emitBlkioPerDevice sends the per device block I/O bytes of a container.
+func emitBlkioPerDevice(ch chan<- prometheus.Metric, devices []blkioDevice, labels []string) {
+	for _, device := range devices {
+		name := deviceNames.resolve(device.major, device.minor)
+		ch <- prometheus.MustNewConstMetric(blkioDeviceReadBytesDesc, prometheus.CounterValue, float64(device.read), append(labels, name)...)
+		ch <- prometheus.MustNewConstMetric(blkioDeviceWriteBytesDesc, prometheus.CounterValue, float64(device.write), append(labels, name)...)
+	}
+}
+
+// deviceNameCache resolves block device numbers to kernel device names.
+type deviceNameCache struct {
+	mu    sync.Mutex
+	names map[string]string
+}
+
+var deviceNames = &deviceNameCache{names: map[string]string{}}
+
+// resolve returns the device name from sysfs, e.g. sda, falling back to
+// major:minor when it can't be found.
+func (c *deviceNameCache) resolve(major, minor uint64) string {
+	number := fmt.Sprintf("%d:%d", major, minor)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if name, ok := c.names[number]; ok {
+		return name
+	}
+
+	name := number
+	if devName := readDevName(filepath.Join(*sysfsRoot, "dev", "block", number, "uevent")); devName != "" {
+		name = devName
+	}
+	c.names[number] = name
+	return name
+}
+
+// readDevName returns the DEVNAME of a sysfs uevent file, or an empty string.
+func readDevName(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if name, ok := strings.CutPrefix(scanner.Text(), "DEVNAME="); ok {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"github.com/docker/docker/api/types"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBlkioPerDeviceBytes(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []types.BlkioStatEntry
+		want    []blkioDevice
+	}{
+		{
+			name: "two devices, cgroup v2",
+			entries: []types.BlkioStatEntry{
+				{Major: 259, Minor: 0, Op: "read", Value: 100},
+				{Major: 259, Minor: 0, Op: "write", Value: 200},
+				{Major: 8, Minor: 16, Op: "read", Value: 300},
+				{Major: 8, Minor: 16, Op: "write", Value: 400},
+			},
+			want: []blkioDevice{
+				{major: 8, minor: 16, read: 300, write: 400},
+				{major: 259, minor: 0, read: 100, write: 200},
+			},
+		},
+		{
+			name: "cgroup v1 with Sync, Async and Total",
+			entries: []types.BlkioStatEntry{
+				{Major: 8, Minor: 0, Op: "Read", Value: 100},
+				{Major: 8, Minor: 0, Op: "Write", Value: 200},
+				{Major: 8, Minor: 0, Op: "Sync", Value: 250},
+				{Major: 8, Minor: 0, Op: "Async", Value: 50},
+				{Major: 8, Minor: 0, Op: "Discard", Value: 0},
+				{Major: 8, Minor: 0, Op: "Total", Value: 300},
+				{Major: 8, Minor: 16, Op: "Read", Value: 10},
+				{Major: 8, Minor: 16, Op: "Total", Value: 10},
+			},
+			want: []blkioDevice{
+				{major: 8, minor: 0, read: 100, write: 200},
+				{major: 8, minor: 16, read: 10},
+			},
+		},
+		{
+			name: "no entries",
+			want: []blkioDevice{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := blkioPerDeviceBytes(tt.entries); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("blkioPerDeviceBytes() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeviceNameResolve(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "dev", "block", "8:0")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "uevent"), []byte("MAJOR=8\nMINOR=0\nDEVNAME=sda\nDEVTYPE=disk\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	setTestFlag(t, "collector.blkio-per-device.sysfs-root", root)
+
+	c := &deviceNameCache{names: map[string]string{}}
+	if got := c.resolve(8, 0); got != "sda" {
+		t.Errorf("resolve(8, 0) = %q, want sda", got)
+	}
+	if got := c.resolve(253, 7); got != "253:7" {
+		t.Errorf("resolve(253, 7) of an unknown device = %q, want 253:7", got)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"log"
+	"sync"
+)
+
+// cgroupInfo caches the daemon's cgroup version and driver, which can't
+// change while the daemon runs.
+type cgroupInfo struct {
+	mu      sync.Mutex
+	fetched bool
+	version string
+	driver  string
+}
+
+// collect emits docker_exporter_cgroup_info, asking the daemon on the first
+// call that succeeds.
+func (ci *cgroupInfo) collect(ctx context.Context, ch chan<- prometheus.Metric, cli *client.Client, limiter *rate.Limiter) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	if !ci.fetched {
+		if err := limiter.Wait(ctx); err != nil {
+			log.Println("Failed to get Docker info:", err)
+			return
+		}
+		info, err := cli.Info(ctx)
+		if err != nil {
+			log.Println("Failed to get Docker info:", err)
+			return
+		}
+		ci.version = info.CgroupVersion
+		// Daemons from before cgroup v2 support don't report the version.
+		if ci.version == "" {
+			ci.version = "1"
+		}
+		ci.driver = info.CgroupDriver
+		ci.fetched = true
+	}
+
+	ch <- prometheus.MustNewConstMetric(cgroupInfoDesc, prometheus.GaugeValue, 1, ci.version, ci.driver)
+}
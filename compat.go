@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	metricsCompat = flag.String("metrics.compat", "", "Export the metrics that have a cAdvisor equivalent under cAdvisor's names and labels. The only supported value is cadvisor.")
+)
+
+// Only exported in -metrics.compat=cadvisor mode, nil otherwise.
+var (
+	cpuUsageSecondsDesc *prometheus.Desc
+	memoryUsageRawDesc  *prometheus.Desc
+)
+
+// validateCompat checks -metrics.compat.
+func validateCompat() error {
+	if *metricsCompat != "" && *metricsCompat != "cadvisor" {
+		return fmt.Errorf("invalid -metrics.compat %q, must be cadvisor or empty", *metricsCompat)
+	}
+	return nil
+}
+
+// cadvisorLabelNames are the container labels of cAdvisor's metrics, without
+// the Kubernetes specific ones.
+var cadvisorLabelNames = []string{"id", "name", "image"}
+
+// cadvisorLabelValues returns the values for cadvisorLabelNames. cAdvisor's id
+// is the cgroup path, which is /docker/<id> with the cgroupfs driver.
+func cadvisorLabelValues(id, name, image string) []string {
+	return []string{"/docker/" + id, name, image}
+}
+
+// initCadvisorDescs replaces the descriptors that have a cAdvisor
+// equivalent with ones named like cAdvisor's.
+func initCadvisorDescs() {
+	cpuUsageSecondsDesc = newCadvisorDesc("container_cpu_usage_seconds_total", "Cumulative cpu time consumed in seconds.")
+	cpuKernelModeDesc = newCadvisorDesc("container_cpu_system_seconds_total", "Cumulative system cpu time consumed in seconds.")
+	cpuUserModeDesc = newCadvisorDesc("container_cpu_user_seconds_total", "Cumulative user cpu time consumed in seconds.")
+	cpuPeriodsDesc = newCadvisorDesc("container_cpu_cfs_periods_total", "Number of elapsed enforcement period intervals.")
+	cpuThrottledPeriodsDesc = newCadvisorDesc("container_cpu_cfs_throttled_periods_total", "Number of throttled period intervals.")
+	cpuThrottledTimeDesc = newCadvisorDesc("container_cpu_cfs_throttled_seconds_total", "Total time duration the container has been throttled.")
+	memoryUsageRawDesc = newCadvisorDesc("container_memory_usage_bytes", "Current memory usage in bytes, including all memory regardless of when it was accessed")
+	memoryCacheDesc = newCadvisorDesc("container_memory_cache", "Number of bytes of page cache memory.")
+	memoryRSSDesc = newCadvisorDesc("container_memory_rss", "Size of RSS in bytes.")
+	memorySwapDesc = newCadvisorDesc("container_memory_swap", "Container swap usage in bytes.")
+}
+
+// newCadvisorDesc creates a descriptor without the exporter's namespace,
+// labeled with containerLabelNames.
+func newCadvisorDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(name, help, append([]string{}, containerLabelNames...), nil)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"github.com/docker/docker/api/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"sort"
+)
+
+var (
+	aggregateCompose = flag.Bool("metrics.aggregate-compose-services", false, "Export the CPU, memory and network usage of Compose containers summed per service instead of per container. Other containers are still exported per container.")
+)
+
+// composeServiceKey identifies a Compose service.
+type composeServiceKey struct {
+	project, service string
+}
+
+// composeService returns the Compose service of a container, with an empty
+// service if it doesn't belong to one.
+func composeService(result *containerResult) composeServiceKey {
+	return composeServiceKey{
+		project: result.container.Labels[composeProjectLabel],
+		service: result.container.Labels[composeServiceLabel],
+	}
+}
+
+// composeServiceUsage is the usage summed over the containers of a service.
+type composeServiceUsage struct {
+	containers int
+	cpu        float64
+	memory     float64
+	// network holds the counters summed over all interfaces.
+	network types.NetworkStats
+}
+
+// standaloneContainers returns the results that don't belong to a Compose
+// service.
+func standaloneContainers(results []*containerResult) []*containerResult {
+	var standalone []*containerResult
+	for _, result := range results {
+		if composeService(result).service == "" {
+			standalone = append(standalone, result)
+		}
+	}
+	return standalone
+}
+
+// emitComposeServices sends the summed usage of every Compose service in
+// results. Containers without stats only count towards the number of
+// containers.
+func emitComposeServices(ch chan<- prometheus.Metric, results []*containerResult) {
+	services := map[composeServiceKey]*composeServiceUsage{}
+	for _, result := range results {
+		key := composeService(result)
+		if key.service == "" {
+			continue
+		}
+
+		usage, ok := services[key]
+		if !ok {
+			usage = &composeServiceUsage{}
+			services[key] = usage
+		}
+		usage.containers++
+		if result.metrics != nil {
+			usage.cpu += result.metrics.cpuUsagePercent
+			usage.memory += float64(result.metrics.memoryUsageBytes)
+			for _, network := range result.metrics.networks {
+				usage.network.RxBytes += network.RxBytes
+				usage.network.TxBytes += network.TxBytes
+				usage.network.RxPackets += network.RxPackets
+				usage.network.TxPackets += network.TxPackets
+				usage.network.RxDropped += network.RxDropped
+				usage.network.TxDropped += network.TxDropped
+			}
+		}
+	}
+
+	keys := make([]composeServiceKey, 0, len(services))
+	for key := range services {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].project != keys[j].project {
+			return keys[i].project < keys[j].project
+		}
+		return keys[i].service < keys[j].service
+	})
+
+	for _, key := range keys {
+		usage := services[key]
+		ch <- prometheus.MustNewConstMetric(composeServiceContainersDesc, prometheus.GaugeValue, float64(usage.containers), key.project, key.service)
+		ch <- prometheus.MustNewConstMetric(composeServiceCPUUsageDesc, prometheus.GaugeValue, usage.cpu, key.project, key.service)
+		ch <- prometheus.MustNewConstMetric(composeServiceMemoryUsageDesc, prometheus.GaugeValue, usage.memory, key.project, key.service)
+		ch <- prometheus.MustNewConstMetric(composeServiceNetworkRxBytesDesc, prometheus.CounterValue, float64(usage.network.RxBytes), key.project, key.service)
+		ch <- prometheus.MustNewConstMetric(composeServiceNetworkTxBytesDesc, prometheus.CounterValue, float64(usage.network.TxBytes), key.project, key.service)
+		ch <- prometheus.MustNewConstMetric(composeServiceNetworkRxPacketsDesc, prometheus.CounterValue, float64(usage.network.RxPackets), key.project, key.service)
+		ch <- prometheus.MustNewConstMetric(composeServiceNetworkTxPacketsDesc, prometheus.CounterValue, float64(usage.network.TxPackets), key.project, key.service)
+		ch <- prometheus.MustNewConstMetric(composeServiceNetworkRxDroppedDesc, prometheus.CounterValue, float64(usage.network.RxDropped), key.project, key.service)
+		ch <- prometheus.MustNewConstMetric(composeServiceNetworkTxDroppedDesc, prometheus.CounterValue, float64(usage.network.TxDropped), key.project, key.service)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/docker/docker/api/types"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"testing"
+)
+
+// composeResult returns a result of a container of the Compose service, or a
+// standalone one if service is empty.
+func composeResult(id, service string, cpu float64, rxBytes uint64) *containerResult {
+	labels := map[string]string{}
+	if service != "" {
+		labels[composeProjectLabel] = "shop"
+		labels[composeServiceLabel] = service
+	}
+	return &containerResult{
+		container: types.Container{ID: id, Labels: labels},
+		metrics: &containerMetrics{
+			cpuUsagePercent: cpu,
+			networks: map[string]types.NetworkStats{
+				"eth0": {RxBytes: rxBytes},
+				"eth1": {RxBytes: 1},
+			},
+		},
+	}
+}
+
+func TestComposeServices(t *testing.T) {
+	initDescs()
+	web1 := composeResult("1", "web", 10, 100)
+	web2 := composeResult("2", "web", 5, 200)
+	standalone := composeResult("3", "", 50, 0)
+
+	ch := make(chan prometheus.Metric, 100)
+	// web2 is left out by -docker.top-n but still counts for the service.
+	emitComposeServices(ch, []*containerResult{web1, web2, standalone})
+	close(ch)
+
+	values := map[*prometheus.Desc]float64{}
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		if service, _ := labelValue(&pb, "compose_service"); service != "web" {
+			t.Errorf("%v has compose_service %q, want web", m.Desc(), service)
+		}
+		values[m.Desc()] = pb.GetGauge().GetValue() + pb.GetCounter().GetValue()
+	}
+	want := map[*prometheus.Desc]float64{
+		composeServiceContainersDesc:     2,
+		composeServiceCPUUsageDesc:       15,
+		composeServiceNetworkRxBytesDesc: 302,
+	}
+	for desc, value := range want {
+		if values[desc] != value {
+			t.Errorf("%v = %v, want %v", desc, values[desc], value)
+		}
+	}
+
+	got := standaloneContainers([]*containerResult{web1, standalone})
+	if len(got) != 1 || got[0] != standalone {
+		t.Errorf("standaloneContainers() = %v, want only the standalone container", got)
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var (
+	configFile = flag.String("config.file", "", "Path to a YAML config file mapping flag names (without the leading dash) to values. Flags given on the command line take precedence.")
+)
+
+// envPrefix starts the names of the environment variables that set flags,
+// see flagEnvName.
+const envPrefix = "DOCKER_EXPORTER_"
+
+// reloadableFlags are the settings that a config reload applies live. Changes
+// to any other setting are reported as requiring a restart.
+var reloadableFlags = map[string]bool{
+	"docker.exclude-label":         true,
+	"docker.exclude-name":          true,
+	"docker.expose-env":            true,
+	"docker.image-regexp":          true,
+	"docker.include-label":         true,
+	"docker.include-name":          true,
+	"docker.label-allowlist":       true,
+	"docker.label-template":        true,
+	"docker.per-container-timeout": true,
+	"log.level":                    true,
+}
+
+// config tracks the settings managed by the config file.
+type config struct {
+	path string
+
+	// cmdline holds the flags given on the command line, which the config
+	// file never overrides.
+	cmdline map[string]bool
+	// applied holds the values last applied from the config file.
+	applied map[string][]string
+}
+
+// flagEnvName returns the environment variable that sets a flag, e.g.
+// DOCKER_EXPORTER_WEB_LISTEN_ADDRESS for -web.listen-address.
+func flagEnvName(name string) string {
+	return envPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(name))
+}
+
+// applyEnv sets the flags that weren't given on the command line from their
+// environment variables. Repeatable flags take a comma separated list. Flags
+// set this way count as given on the command line, so that the config file
+// doesn't override them.
+func applyEnv() error {
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(flagEnvName(f.Name))
+		if !ok || given[f.Name] || err != nil {
+			return
+		}
+		values := []string{value}
+		if _, repeatable := f.Value.(*stringsFlag); repeatable {
+			values = strings.Split(value, ",")
+		}
+		if setErr := setFlag(f.Name, values); setErr != nil {
+			err = fmt.Errorf("$%s: %w", flagEnvName(f.Name), setErr)
+		}
+	})
+	return err
+}
+
+// loadConfig reads the config file, if any, and applies it on top of the
+// parsed command line flags.
+func loadConfig() (*config, error) {
+	cfg := &config{
+		path:    *configFile,
+		cmdline: map[string]bool{},
+		applied: map[string][]string{},
+	}
+	flag.Visit(func(f *flag.Flag) {
+		cfg.cmdline[f.Name] = true
+	})
+	if cfg.path == "" {
+		return cfg, nil
+	}
+
+	values, err := cfg.read()
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range values {
+		if err := setFlag(name, value); err != nil {
+			return nil, err
+		}
+		cfg.applied[name] = value
+	}
+	return cfg, nil
+}
+
+// read parses the config file into flag values. Lists are used for flags
+// that can be given more than once.
+func (cfg *config) read() (map[string][]string, error) {
+	data, err := os.ReadFile(cfg.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", cfg.path, err)
+	}
+
+	values := make(map[string][]string, len(raw))
+	for name, v := range raw {
+		if flag.Lookup(name) == nil || name == "config.file" {
+			return nil, fmt.Errorf("unknown setting %q in %s", name, cfg.path)
+		}
+		if cfg.cmdline[name] {
+			continue
+		}
+
+		switch v := v.(type) {
+		case []interface{}:
+			for _, item := range v {
+				values[name] = append(values[name], fmt.Sprint(item))
+			}
+		case nil:
+			values[name] = []string{""}
+		default:
+			values[name] = []string{fmt.Sprint(v)}
+		}
+	}
+	return values, nil
+}
+
+// reload re-reads the config file and applies the reloadable settings to
+// dcs. It returns the names of changed settings that need a restart to apply.
+func (cfg *config) reload(dcs []*dockerCollector) ([]string, error) {
+	if cfg.path == "" {
+		return nil, errors.New("no config file configured, see -config.file")
+	}
+
+	// The collectors share the descriptors, so none of them may collect
+	// until all have the new settings.
+	for _, dc := range dcs {
+		dc.mu.Lock()
+		defer dc.mu.Unlock()
+	}
+
+	values, err := cfg.read()
+	if err != nil {
+		return nil, err
+	}
+
+	// Settings removed from the file go back to their defaults.
+	for name := range cfg.applied {
+		if _, ok := values[name]; !ok {
+			values[name] = []string{flag.Lookup(name).DefValue}
+		}
+	}
+
+	var restart []string
+	previous := map[string][]string{}
+	for name, value := range values {
+		if strings.Join(value, "\n") == strings.Join(cfg.applied[name], "\n") {
+			continue
+		}
+		if !reloadableFlags[name] {
+			restart = append(restart, name)
+			continue
+		}
+
+		previous[name] = flagValues(name)
+		if err := setFlag(name, value); err != nil {
+			restoreFlags(previous)
+			return nil, err
+		}
+	}
+
+	if err := applySettings(dcs); err != nil {
+		restoreFlags(previous)
+		if restoreErr := applySettings(dcs); restoreErr != nil {
+			log.Println("Failed to restore previous settings:", restoreErr)
+		}
+		return nil, err
+	}
+
+	for name := range previous {
+		if fromFile, ok := values[name]; ok {
+			cfg.applied[name] = fromFile
+		}
+	}
+	sort.Strings(restart)
+	return restart, nil
+}
+
+// watchReloadSignal reloads the config file whenever the process gets SIGHUP.
+func (cfg *config) watchReloadSignal(dcs []*dockerCollector) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			cfg.logReload(dcs)
+		}
+	}()
+}
+
+func (cfg *config) logReload(dcs []*dockerCollector) ([]string, error) {
+	restart, err := cfg.reload(dcs)
+	if err != nil {
+		log.Println("Failed to reload config:", err)
+		return nil, err
+	}
+	if len(restart) > 0 {
+		log.Println("Reloaded config, changes to", strings.Join(restart, ", "), "require a restart")
+	} else {
+		log.Println("Reloaded config")
+	}
+	return restart, nil
+}
+
+// reloadHandler serves POST /-/reload, following the Prometheus convention.
+func (cfg *config) reloadHandler(dcs []*dockerCollector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		restart, err := cfg.logReload(dcs)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+			return
+		}
+		if len(restart) > 0 {
+			fmt.Fprintf(w, "Config reloaded, restart required to apply: %s\n", strings.Join(restart, ", "))
+			return
+		}
+		fmt.Fprintln(w, "Config reloaded")
+	}
+}
+
+// applySettings applies the current flag values to all of dcs.
+func applySettings(dcs []*dockerCollector) error {
+	for _, dc := range dcs {
+		if err := dc.applySettings(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setFlag sets a flag to values. Repeatable flags are reset first, so that
+// their values replace the previous ones, and empty values are skipped.
+func setFlag(name string, values []string) error {
+	repeated, repeatable := flag.Lookup(name).Value.(*stringsFlag)
+	if repeatable {
+		*repeated = nil
+	}
+	for _, value := range values {
+		if repeatable && value == "" {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %w", value, name, err)
+		}
+	}
+	return nil
+}
+
+// flagValues returns the values of a flag, as setFlag takes them.
+func flagValues(name string) []string {
+	value := flag.Lookup(name).Value
+	if repeated, ok := value.(*stringsFlag); ok {
+		return append([]string{}, *repeated...)
+	}
+	return []string{value.String()}
+}
+
+func restoreFlags(values map[string][]string) {
+	for name, value := range values {
+		if err := setFlag(name, value); err != nil {
+			log.Println("Failed to restore", name, ":", err)
+		}
+	}
+}
+
+// newConfigInfo returns docker_exporter_config_info, summarizing the active
+// configuration so that a fleet of exporters can be compared in Prometheus.
+// It must only ever include settings that can't hold secrets.
+func newConfigInfo() prometheus.Gauge {
+	var enabled []string
+	flag.VisitAll(func(f *flag.Flag) {
+		name, ok := strings.CutPrefix(f.Name, "collector.")
+		if ok && !strings.Contains(name, ".") && f.Value.String() == "true" {
+			enabled = append(enabled, name)
+		}
+	})
+
+	labelMode := "container_id"
+	switch {
+	case *metricsCompat == "cadvisor":
+		labelMode = "cadvisor"
+	case *noIDLabel:
+		labelMode = "no_id"
+	}
+
+	info := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_info",
+		Help:      "Summary of the exporter's configuration at startup; always 1",
+		ConstLabels: prometheus.Labels{
+			"collectors":         strings.Join(enabled, ","),
+			"image_filter":       strconv.FormatBool(*imageRegexp != ""),
+			"label_mode":         labelMode,
+			"networks_cache_ttl": networksCacheTTL.String(),
+			"refresh_interval":   refreshInterval.String(),
+			"rate_limit":         strconv.FormatFloat(*rateLimit, 'f', -1, 64),
+			"concurrency":        strconv.Itoa(*workers),
+		},
+	})
+	info.Set(1)
+	return info
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"github.com/docker/docker/api/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestReloadDuringScrape(t *testing.T) {
+	setDockerHosts(t, newFakeDaemon(t, "a1", "a2").host())
+	setTestFlag(t, "collector.networks", "true")
+	setTestFlag(t, "docker.label-allowlist", "")
+
+	daemons, err := newDaemons()
+	if err != nil {
+		t.Fatalf("newDaemons() error = %v", err)
+	}
+	var dcs []*dockerCollector
+	for _, d := range daemons {
+		dcs = append(dcs, d.collector)
+	}
+	if err := applySettings(dcs); err != nil {
+		t.Fatalf("applySettings() error = %v", err)
+	}
+	// Like main, the registry isn't pedantic: the reloads change the
+	// registered container descriptors.
+	reg := prometheus.NewRegistry()
+	for _, d := range daemons {
+		d.register(reg)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	cfg := &config{path: path, cmdline: map[string]bool{}, applied: map[string][]string{}}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				if _, err := reg.Gather(); err != nil {
+					t.Errorf("Gather() error = %v", err)
+					return
+				}
+			}
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		label := []string{"a", "b"}[i%2]
+		if err := os.WriteFile(path, []byte("docker.label-allowlist: "+label+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cfg.reload(dcs); err != nil {
+			t.Fatalf("reload() error = %v", err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestReloadFilters(t *testing.T) {
+	setDockerHosts(t, newFakeDaemon(t).host())
+	setTestFlag(t, "docker.image-regexp", "")
+	previous := append(stringsFlag{}, includeLabels...)
+	t.Cleanup(func() { includeLabels = previous })
+	includeLabels = nil
+
+	daemons, err := newDaemons()
+	if err != nil {
+		t.Fatalf("newDaemons() error = %v", err)
+	}
+	dc := daemons[0].collector
+	if err := dc.applySettings(); err != nil {
+		t.Fatalf("applySettings() error = %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	cfg := &config{path: path, cmdline: map[string]bool{}, applied: map[string][]string{}}
+	steps := []struct {
+		config     string
+		wantLabels []labelMatcher
+		wantImage  bool
+	}{
+		{"docker.include-label: [app, env=prod]\ndocker.image-regexp: nginx.*\n", []labelMatcher{{key: "app", anyValue: true}, {key: "env", value: "prod"}}, true},
+		{"docker.include-label: [team]\n", []labelMatcher{{key: "team", anyValue: true}}, false},
+		{"{}\n", nil, false},
+	}
+	for _, step := range steps {
+		if err := os.WriteFile(path, []byte(step.config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		restart, err := cfg.reload([]*dockerCollector{dc})
+		if err != nil {
+			t.Fatalf("reload() error = %v", err)
+		}
+		if len(restart) > 0 {
+			t.Errorf("reload() of %q requires a restart for %v", step.config, restart)
+		}
+		if !reflect.DeepEqual(dc.filter.includeLabels, step.wantLabels) {
+			t.Errorf("after %q: include labels = %+v, want %+v", step.config, dc.filter.includeLabels, step.wantLabels)
+		}
+		if got := dc.imageRegexp != nil; got != step.wantImage {
+			t.Errorf("after %q: image regexp set = %v, want %v", step.config, got, step.wantImage)
+		}
+	}
+}
+
+func TestReloadStatsStreams(t *testing.T) {
+	// The stats streams stay open until they are closed.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	setDockerHosts(t, "tcp://"+srv.Listener.Addr().String())
+	setTestFlag(t, "docker.image-regexp", "")
+	setTestFlag(t, "docker.exclude-name", "b.*")
+
+	daemons, err := newDaemons()
+	if err != nil {
+		t.Fatalf("newDaemons() error = %v", err)
+	}
+	dc := daemons[0].collector
+	if err := dc.applySettings(); err != nil {
+		t.Fatalf("applySettings() error = %v", err)
+	}
+	dc.streams = newStatsStreams(dc)
+	t.Cleanup(func() {
+		dc.mu.RLock()
+		dc.streams.sync(nil)
+		dc.mu.RUnlock()
+	})
+
+	streamed := func() map[string]bool {
+		dc.streams.mu.Lock()
+		defer dc.streams.mu.Unlock()
+		ids := map[string]bool{}
+		for id := range dc.streams.streams {
+			ids[id] = true
+		}
+		return ids
+	}
+
+	dc.mu.RLock()
+	dc.streams.sync([]types.Container{
+		{ID: "a", Names: []string{"/a1"}, State: "running"},
+		{ID: "b", Names: []string{"/b1"}, State: "running"},
+		{ID: "c", Names: []string{"/a2"}, State: "exited"},
+	})
+	dc.mu.RUnlock()
+	if got, want := streamed(), map[string]bool{"a": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("streams = %v, want %v", got, want)
+	}
+
+	setTestFlag(t, "docker.exclude-name", "a.*")
+	dc.mu.Lock()
+	err = dc.applySettings()
+	dc.mu.Unlock()
+	if err != nil {
+		t.Fatalf("applySettings() error = %v", err)
+	}
+	if got, want := streamed(), map[string]bool{"b": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("streams after reload = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/docker/docker/client"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	useContexts = flag.String("docker.use-contexts", "", "Collect from the Docker CLI contexts with these comma separated names instead of -docker.host, each labeled with docker_context. * selects all contexts, including default.")
+)
+
+// dockerContext is an endpoint from the Docker CLI context store.
+type dockerContext struct {
+	name string
+	host string
+	// tlsDir holds the ca.pem, cert.pem and key.pem of the endpoint, any of
+	// which may be missing.
+	tlsDir        string
+	skipTLSVerify bool
+}
+
+// contextMeta is the part of a context's meta.json the exporter uses.
+type contextMeta struct {
+	Name      string
+	Endpoints map[string]struct {
+		Host          string
+		SkipTLSVerify bool
+	}
+}
+
+// dockerConfigDir returns the Docker CLI configuration directory.
+func dockerConfigDir() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".docker"), nil
+}
+
+// loadDockerContexts reads the contexts selected by -docker.use-contexts.
+// default stands for the daemon configured by the -docker.* flags and is
+// returned with an empty host. Contexts that can't be read are logged and
+// skipped, it is only an error if none of the selected ones are left.
+func loadDockerContexts() ([]dockerContext, error) {
+	configDir, err := dockerConfigDir()
+	if err != nil {
+		return nil, err
+	}
+
+	contexts := map[string]dockerContext{"default": {name: "default"}}
+	metaDirs, err := filepath.Glob(filepath.Join(configDir, "contexts", "meta", "*", "meta.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range metaDirs {
+		c, err := readContextMeta(configDir, path)
+		if err != nil {
+			log.Println("Skipping Docker context", path, ":", err)
+			continue
+		}
+		contexts[c.name] = c
+	}
+
+	var selected []dockerContext
+	if *useContexts == "*" {
+		for _, c := range contexts {
+			selected = append(selected, c)
+		}
+	} else {
+		for _, name := range strings.Split(*useContexts, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			c, ok := contexts[name]
+			if !ok {
+				log.Println("Skipping unknown Docker context", name)
+				continue
+			}
+			selected = append(selected, c)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no usable Docker contexts found in %s", filepath.Join(configDir, "contexts"))
+	}
+
+	sort.Slice(selected, func(i, j int) bool {
+		return selected[i].name < selected[j].name
+	})
+	return selected, nil
+}
+
+// readContextMeta reads a meta.json file of the context store. The TLS files
+// of a context live in a directory with the same name as its meta directory.
+func readContextMeta(configDir, path string) (dockerContext, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return dockerContext{}, err
+	}
+	var meta contextMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return dockerContext{}, err
+	}
+	endpoint, ok := meta.Endpoints["docker"]
+	if meta.Name == "" || !ok || endpoint.Host == "" {
+		return dockerContext{}, errors.New("no Docker endpoint")
+	}
+	if strings.HasPrefix(endpoint.Host, "ssh://") {
+		return dockerContext{}, fmt.Errorf("context %s: ssh endpoints are not supported", meta.Name)
+	}
+
+	id := filepath.Base(filepath.Dir(path))
+	return dockerContext{
+		name:          meta.Name,
+		host:          endpoint.Host,
+		tlsDir:        filepath.Join(configDir, "contexts", "tls", id, "docker"),
+		skipTLSVerify: endpoint.SkipTLSVerify,
+	}, nil
+}
+
+// newClient creates a Docker API client for the context.
+func (c dockerContext) newClient() (*client.Client, error) {
+	if c.host == "" {
+		return newDefaultClient()
+	}
+
+	tlsConfig, err := c.loadTLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("context %s: %w", c.name, err)
+	}
+
+	// Unlike the default context, other contexts don't use -docker.bearer-token.
+	opts := append(clientOpts(c.host, tlsConfig), client.WithHTTPHeaders(clientHeaders()))
+	return client.NewClientWithOpts(opts...)
+}
+
+// loadTLSConfig builds the TLS config from the context's TLS files, or
+// returns nil when it has none.
+func (c dockerContext) loadTLSConfig() (*tls.Config, error) {
+	read := func(name string) ([]byte, error) {
+		data, err := os.ReadFile(filepath.Join(c.tlsDir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return data, err
+	}
+	ca, err := read("ca.pem")
+	if err != nil {
+		return nil, err
+	}
+	cert, err := read("cert.pem")
+	if err != nil {
+		return nil, err
+	}
+	key, err := read("key.pem")
+	if err != nil {
+		return nil, err
+	}
+	if ca == nil && cert == nil && !c.skipTLSVerify {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.skipTLSVerify,
+	}
+	if ca != nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("no valid certificates found in ca.pem")
+		}
+		config.RootCAs = pool
+	}
+	if cert != nil {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("loading cert.pem and key.pem: %w", err)
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config, nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+)
+
+// daemon is a Docker daemon the exporter collects from.
+type daemon struct {
+	// labelName and name tell the daemon's metrics apart from those of the
+	// other daemons. Both are empty when there is only one.
+	labelName string
+	name      string
+	collector *dockerCollector
+}
+
+// newDaemons creates a daemon for every context selected by
+// -docker.use-contexts, or for every -docker.host and -docker.endpoints-file
+// entry. Each daemon gets its own collector, so that an error on one of them
+// doesn't affect the others.
+func newDaemons() ([]daemon, error) {
+	if err := loadDockerEndpoints(); err != nil {
+		return nil, err
+	}
+	if *useContexts != "" {
+		if multipleHosts() {
+			return nil, errors.New("-docker.use-contexts can't be combined with several -docker.host or -docker.endpoints-file")
+		}
+		contexts, err := loadDockerContexts()
+		if err != nil {
+			return nil, err
+		}
+		daemons := make([]daemon, 0, len(contexts))
+		for _, c := range contexts {
+			cli, err := c.newClient()
+			if err != nil {
+				return nil, err
+			}
+			daemons = append(daemons, daemon{labelName: "docker_context", name: c.name, collector: newDockerCollector(cli)})
+		}
+		return daemons, nil
+	}
+
+	if !multipleHosts() {
+		cli, err := newDefaultClient()
+		if err != nil {
+			return nil, err
+		}
+		return []daemon{{collector: newDockerCollector(cli)}}, nil
+	}
+
+	daemons := make([]daemon, 0, len(dockerHosts)+len(dockerEndpoints))
+	for _, host := range dockerHosts {
+		cli, err := newDockerClient(host)
+		if err != nil {
+			return nil, err
+		}
+		daemons = append(daemons, daemon{labelName: "docker_host", name: host, collector: newDockerCollector(cli)})
+	}
+	for _, e := range dockerEndpoints {
+		cli, err := e.newClient()
+		if err != nil {
+			return nil, err
+		}
+		daemons = append(daemons, daemon{labelName: "docker_host", name: e.Host, collector: newDockerCollector(cli)})
+	}
+	return daemons, nil
+}
+
+// newDefaultClient creates the client of the only daemon, from the only
+// -docker.host or -docker.endpoints-file entry, falling back to $DOCKER_HOST.
+func newDefaultClient() (*client.Client, error) {
+	if len(dockerEndpoints) == 1 {
+		return dockerEndpoints[0].newClient()
+	}
+	if len(dockerHosts) == 1 {
+		return newDockerClient(dockerHosts[0])
+	}
+	return newDockerClient("")
+}
+
+// wrap returns a registerer that adds the label identifying the daemon.
+func (d daemon) wrap(reg prometheus.Registerer) prometheus.Registerer {
+	if d.labelName == "" {
+		return reg
+	}
+	return prometheus.WrapRegistererWith(prometheus.Labels{d.labelName: d.name}, reg)
+}
+
+// register registers the container collector and the enabled optional
+// collectors of the daemon, and starts following its events if enabled.
+func (d daemon) register(reg prometheus.Registerer) {
+	reg = d.wrap(reg)
+	dc := d.collector
+
+	reg.MustRegister(dc)
+	if *collectNetworks {
+		reg.MustRegister(newNetworkCollector(dc.dockerClient, dc.limiter))
+	}
+	if *collectDiskUsage {
+		duc := newDiskUsageCollector(dc.dockerClient, dc.limiter)
+		duc.start(*diskUsageInterval)
+		dc.diskUsage = duc
+		reg.MustRegister(duc)
+	}
+	if *collectSwarmNodes {
+		if snc := newSwarmNodeCollector(dc.dockerClient, dc.limiter); snc.usable() {
+			reg.MustRegister(snc)
+		}
+	}
+	if *collectSwarmServices {
+		if ssc := newSwarmServiceCollector(dc.dockerClient, dc.limiter); ssc.usable() {
+			reg.MustRegister(ssc)
+		}
+	}
+	if *collectEvents {
+		w := newEventWatcher(dc.dockerClient, dc.limiter)
+		reg.MustRegister(w.imagePulls, w.containerEvents)
+		w.start()
+	}
+}
+
+// checkLabels registers the collectors that register would register with
+// reg, to find labels clashing with the ones reg adds. reg must not be
+// gathered from. Nothing is started, and the swarm collectors are checked
+// even if the daemon turns out not to be a manager.
+func (d daemon) checkLabels(reg prometheus.Registerer) error {
+	reg = d.wrap(reg)
+	dc := d.collector
+
+	collectors := []prometheus.Collector{dc}
+	if *collectNetworks {
+		collectors = append(collectors, newNetworkCollector(dc.dockerClient, dc.limiter))
+	}
+	if *collectDiskUsage {
+		collectors = append(collectors, newDiskUsageCollector(dc.dockerClient, dc.limiter))
+	}
+	if *collectSwarmNodes {
+		collectors = append(collectors, newSwarmNodeCollector(dc.dockerClient, dc.limiter))
+	}
+	if *collectSwarmServices {
+		collectors = append(collectors, newSwarmServiceCollector(dc.dockerClient, dc.limiter))
+	}
+	if *collectEvents {
+		w := newEventWatcher(dc.dockerClient, dc.limiter)
+		collectors = append(collectors, w.imagePulls, w.containerEvents)
+	}
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inventoryHandler serves the container inventory of the daemons. With
+// several daemons, the one to show is selected by its label in the query,
+// e.g. /containers?docker_host=tcp://a:2375.
+func inventoryHandler(daemons []daemon) http.Handler {
+	if len(daemons) == 1 {
+		return &daemons[0].collector.inventory
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, d := range daemons {
+			if r.URL.Query().Get(d.labelName) == d.name {
+				d.collector.inventory.ServeHTTP(w, r)
+				return
+			}
+		}
+		http.Error(w, "Unknown daemon, select one with the "+daemons[0].labelName+" query parameter", http.StatusNotFound)
+	})
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// apiVersionPrefix matches the version prefix of Docker API paths.
+var apiVersionPrefix = regexp.MustCompile(`^/v[0-9.]+/`)
+
+// fakeContainer is a running container of a fakeDaemon.
+type fakeContainer struct {
+	id           string
+	name         string
+	restartCount int
+}
+
+// fakeDaemon is a fake Docker API serving its containers.
+type fakeDaemon struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	containers []fakeContainer
+	// failing makes every API call but the ping fail.
+	failing bool
+}
+
+// newFakeDaemon starts a fakeDaemon with a running container for each of
+// names.
+func newFakeDaemon(t *testing.T, names ...string) *fakeDaemon {
+	fd := &fakeDaemon{}
+	for i, name := range names {
+		fd.containers = append(fd.containers, fakeContainer{id: fmt.Sprintf("%064d", i+1), name: name})
+	}
+	fd.Server = httptest.NewServer(http.HandlerFunc(fd.serve))
+	t.Cleanup(fd.Close)
+	return fd
+}
+
+// setFailing makes the daemon fail every API call but the ping, or not.
+func (fd *fakeDaemon) setFailing(failing bool) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	fd.failing = failing
+}
+
+// setContainers replaces the containers of the daemon.
+func (fd *fakeDaemon) setContainers(containers ...fakeContainer) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	fd.containers = containers
+}
+
+func (fd *fakeDaemon) container(id string) (fakeContainer, bool) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	for _, c := range fd.containers {
+		if c.id == id {
+			return c, true
+		}
+	}
+	return fakeContainer{}, false
+}
+
+func (fd *fakeDaemon) list() []map[string]interface{} {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	list := make([]map[string]interface{}, 0, len(fd.containers))
+	for _, c := range fd.containers {
+		list = append(list, map[string]interface{}{
+			"Id":      c.id,
+			"Names":   []string{"/" + c.name},
+			"Image":   "nginx:1",
+			"ImageID": "sha256:1",
+			"State":   "running",
+			"Created": 1700000000,
+		})
+	}
+	return list
+}
+
+func (fd *fakeDaemon) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Api-Version", "1.43")
+	w.Header().Set("Content-Type", "application/json")
+	path := "/" + apiVersionPrefix.ReplaceAllString(r.URL.Path, "")
+	fd.mu.Lock()
+	failing := fd.failing
+	fd.mu.Unlock()
+
+	var body interface{}
+	switch {
+	case path == "/_ping":
+		w.Write([]byte("OK"))
+		return
+	case failing:
+		w.WriteHeader(http.StatusInternalServerError)
+		body = map[string]string{"message": "fake daemon failure"}
+	case path == "/info":
+		body = map[string]interface{}{"CgroupVersion": "2", "CgroupDriver": "systemd"}
+	case path == "/containers/json":
+		body = fd.list()
+	case strings.HasPrefix(path, "/containers/") && strings.HasSuffix(path, "/json"):
+		c, ok := fd.container(strings.Split(path, "/")[2])
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		body = map[string]interface{}{
+			"Id":           c.id,
+			"Name":         "/" + c.name,
+			"Created":      "2024-01-01T00:00:00Z",
+			"RestartCount": c.restartCount,
+			"State":        map[string]interface{}{"Status": "running", "Running": true, "StartedAt": "2024-01-01T00:00:00Z"},
+			"HostConfig":   map[string]interface{}{},
+			"Config":       map[string]interface{}{},
+		}
+	case strings.HasPrefix(path, "/containers/") && strings.HasSuffix(path, "/stats"):
+		body = map[string]interface{}{
+			"read":         "2024-01-01T00:00:02Z",
+			"preread":      "2024-01-01T00:00:01Z",
+			"cpu_stats":    map[string]interface{}{"cpu_usage": map[string]interface{}{"total_usage": 2000}, "system_cpu_usage": 200000, "online_cpus": 2},
+			"precpu_stats": map[string]interface{}{"cpu_usage": map[string]interface{}{"total_usage": 1000}, "system_cpu_usage": 100000, "online_cpus": 2},
+			"memory_stats": map[string]interface{}{"usage": 1000000, "limit": 2000000, "stats": map[string]uint64{"inactive_file": 1000}},
+		}
+	case path == "/services" || path == "/tasks" || path == "/nodes":
+		w.WriteHeader(http.StatusServiceUnavailable)
+		body = map[string]string{"message": "This node is not a swarm manager."}
+	case path == "/system/df":
+		containers := []map[string]interface{}{}
+		for i, c := range fd.list() {
+			c["SizeRw"] = 1000 * (i + 1)
+			containers = append(containers, c)
+		}
+		body = map[string]interface{}{"Containers": containers}
+	case path == "/networks":
+		body = []map[string]string{{"Id": "n1", "Name": "bridge", "Driver": "bridge", "Scope": "local"}}
+	case strings.HasPrefix(path, "/networks/"):
+		body = map[string]interface{}{"Id": "n1", "Name": "bridge", "Containers": map[string]interface{}{}}
+	case strings.HasPrefix(path, "/images/"):
+		body = map[string]interface{}{"Id": "sha256:1", "Created": "2024-01-01T00:00:00Z"}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(body)
+}
+
+// host returns the -docker.host of the daemon.
+func (fd *fakeDaemon) host() string {
+	return strings.Replace(fd.URL, "http://", "tcp://", 1)
+}
+
+// setDockerHosts sets -docker.host for the duration of the test and rebuilds
+// the descriptors, whose labels depend on the number of hosts.
+func setDockerHosts(t *testing.T, hosts ...string) {
+	previous := dockerHosts
+	dockerHosts = hosts
+	initDescs()
+	t.Cleanup(func() {
+		dockerHosts = previous
+		initDescs()
+	})
+}
+
+// registerDaemons registers the daemons of the current flags like main does.
+func registerDaemons(t *testing.T) *prometheus.Registry {
+	daemons, err := newDaemons()
+	if err != nil {
+		t.Fatalf("newDaemons() error = %v", err)
+	}
+	reg := prometheus.NewPedanticRegistry()
+	for _, d := range daemons {
+		if err := d.collector.applySettings(); err != nil {
+			t.Fatalf("applySettings() error = %v", err)
+		}
+		d.register(reg)
+	}
+	return reg
+}
+
+func gather(t *testing.T, reg *prometheus.Registry) []*dto.MetricFamily {
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	return families
+}
+
+// labelValue returns the value of a label of m, and whether it has it.
+func labelValue(m *dto.Metric, name string) (string, bool) {
+	for _, pair := range m.GetLabel() {
+		if pair.GetName() == name {
+			return pair.GetValue(), true
+		}
+	}
+	return "", false
+}
+
+// seriesByHost returns the values of a metric family by docker_host and
+// container name, the latter empty for host metrics.
+func seriesByHost(families []*dto.MetricFamily, name string) map[string]map[string]float64 {
+	series := map[string]map[string]float64{}
+	for _, mf := range families {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			host, _ := labelValue(m, "docker_host")
+			container, _ := labelValue(m, "name")
+			if series[host] == nil {
+				series[host] = map[string]float64{}
+			}
+			series[host][container] = m.GetGauge().GetValue() + m.GetCounter().GetValue()
+		}
+	}
+	return series
+}
+
+func TestMultipleDaemonsDockerHostLabel(t *testing.T) {
+	hostA := newFakeDaemon(t, "a1", "a2").host()
+	hostB := newFakeDaemon(t, "b1").host()
+	setDockerHosts(t, hostA, hostB)
+
+	families := gather(t, registerDaemons(t))
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			host, ok := labelValue(m, "docker_host")
+			if !ok {
+				t.Errorf("%s has no docker_host label: %v", mf.GetName(), m.GetLabel())
+			} else if host != hostA && host != hostB {
+				t.Errorf("%s has docker_host %q, want %q or %q", mf.GetName(), host, hostA, hostB)
+			}
+		}
+	}
+
+	cpu := seriesByHost(families, "docker_exporter_cpu_usage_percent")
+	want := map[string][]string{hostA: {"a1", "a2"}, hostB: {"b1"}}
+	for host, names := range want {
+		if len(cpu[host]) != len(names) {
+			t.Errorf("cpu_usage_percent of %s has containers %v, want %v", host, cpu[host], names)
+		}
+		for _, name := range names {
+			if _, ok := cpu[host][name]; !ok {
+				t.Errorf("cpu_usage_percent of %s has no series for %s", host, name)
+			}
+		}
+	}
+	for host, value := range seriesByHost(families, "docker_exporter_docker_up") {
+		if value[""] != 1 {
+			t.Errorf("docker_up of %s = %v, want 1", host, value[""])
+		}
+	}
+}
+
+func TestFailingDaemonDoesNotAffectOthers(t *testing.T) {
+	healthy := newFakeDaemon(t, "h1", "h2")
+	failing := newFakeDaemon(t, "f1")
+	failing.setFailing(true)
+	setDockerHosts(t, healthy.host(), failing.host())
+
+	families := gather(t, registerDaemons(t))
+
+	up := seriesByHost(families, "docker_exporter_docker_up")
+	if got := up[healthy.host()][""]; got != 1 {
+		t.Errorf("docker_up of the healthy daemon = %v, want 1", got)
+	}
+	if got, ok := up[failing.host()][""]; !ok || got != 0 {
+		t.Errorf("docker_up of the failing daemon = %v (exported %v), want 0", got, ok)
+	}
+	success := seriesByHost(families, "docker_exporter_scrape_success")
+	if got := success[healthy.host()][""]; got != 1 {
+		t.Errorf("scrape_success of the healthy daemon = %v, want 1", got)
+	}
+	if got, ok := success[failing.host()][""]; !ok || got != 0 {
+		t.Errorf("scrape_success of the failing daemon = %v (exported %v), want 0", got, ok)
+	}
+
+	for _, metric := range []string{"docker_exporter_cpu_usage_percent", "docker_exporter_memory_usage_bytes", "docker_exporter_container_restart_count"} {
+		series := seriesByHost(families, metric)
+		for _, name := range []string{"h1", "h2"} {
+			if _, ok := series[healthy.host()][name]; !ok {
+				t.Errorf("%s of the healthy daemon has no series for %s", metric, name)
+			}
+		}
+		if len(series[failing.host()]) > 0 {
+			t.Errorf("%s of the failing daemon has series %v, want none", metric, series[failing.host()])
+		}
+	}
+}
+
+func TestCheckConstantLabels(t *testing.T) {
+	setDockerHosts(t, newFakeDaemon(t).host(), newFakeDaemon(t).host())
+	daemons, err := newDaemons()
+	if err != nil {
+		t.Fatalf("newDaemons() error = %v", err)
+	}
+
+	tests := []struct {
+		labels  labelsFlag
+		wantErr bool
+	}{
+		{labelsFlag{}, false},
+		{labelsFlag{"datacenter": "eu1", "env": "prod"}, false},
+		{labelsFlag{"name": "web"}, true},
+		{labelsFlag{"docker_host": "a"}, true},
+		{labelsFlag{"code": "200"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.labels.String(), func(t *testing.T) {
+			previous := constantLabels
+			constantLabels = tt.labels
+			defer func() { constantLabels = previous }()
+
+			if err := checkConstantLabels(daemons); (err != nil) != tt.wantErr {
+				t.Errorf("checkConstantLabels() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// containerLabelNames are the variable labels attached to every per-container
+// metric. They are set by applySettings, before initContainerDescs runs.
+var containerLabelNames = []string{"container_id"}
+
+var (
+	upDesc              *prometheus.Desc
+	scrapeSuccessDesc   *prometheus.Desc
+	dockerUpDesc        *prometheus.Desc
+	containersTotalDesc *prometheus.Desc
+
+	cpuUsageDesc      *prometheus.Desc
+	memoryUsageDesc   *prometheus.Desc
+	memoryMaxSeenDesc *prometheus.Desc
+	cpuKernelModeDesc *prometheus.Desc
+	cpuUserModeDesc   *prometheus.Desc
+
+	containerMemoryLimitDesc *prometheus.Desc
+	containerPidsLimitDesc   *prometheus.Desc
+	cpuUsageLimitDesc        *prometheus.Desc
+
+	cpuPeriodsDesc          *prometheus.Desc
+	cpuThrottledPeriodsDesc *prometheus.Desc
+	cpuThrottledTimeDesc    *prometheus.Desc
+	containersThrottledDesc *prometheus.Desc
+
+	containersScrapedDesc          *prometheus.Desc
+	scrapeDurationPerContainerDesc *prometheus.Desc
+
+	scrapeDurationDesc *prometheus.Desc
+
+	totalCPUUsageDesc      *prometheus.Desc
+	totalMemoryUsageDesc   *prometheus.Desc
+	averageCPUUsageDesc    *prometheus.Desc
+	averageMemoryUsageDesc *prometheus.Desc
+
+	memoryCacheDesc      *prometheus.Desc
+	memoryRSSDesc        *prometheus.Desc
+	memorySwapDesc       *prometheus.Desc
+	memoryUsageRatioDesc *prometheus.Desc
+
+	composeServiceContainersDesc  *prometheus.Desc
+	composeServiceCPUUsageDesc    *prometheus.Desc
+	composeServiceMemoryUsageDesc *prometheus.Desc
+
+	composeServiceNetworkRxBytesDesc   *prometheus.Desc
+	composeServiceNetworkTxBytesDesc   *prometheus.Desc
+	composeServiceNetworkRxPacketsDesc *prometheus.Desc
+	composeServiceNetworkTxPacketsDesc *prometheus.Desc
+	composeServiceNetworkRxDroppedDesc *prometheus.Desc
+	composeServiceNetworkTxDroppedDesc *prometheus.Desc
+
+	blkioReadOpsDesc          *prometheus.Desc
+	blkioWriteOpsDesc         *prometheus.Desc
+	blkioDeviceReadBytesDesc  *prometheus.Desc
+	blkioDeviceWriteBytesDesc *prometheus.Desc
+
+	containerStatsSampleTimestampDesc *prometheus.Desc
+
+	containerStartTimeDesc    *prometheus.Desc
+	containerExitCodeDesc     *prometheus.Desc
+	containerHealthStatusDesc *prometheus.Desc
+
+	blkioReadBytesDesc   *prometheus.Desc
+	blkioWriteBytesDesc  *prometheus.Desc
+	networkRxBytesDesc   *prometheus.Desc
+	networkTxBytesDesc   *prometheus.Desc
+	networkRxPacketsDesc *prometheus.Desc
+	networkTxPacketsDesc *prometheus.Desc
+	networkRxDroppedDesc *prometheus.Desc
+	networkTxDroppedDesc *prometheus.Desc
+
+	containerStateDesc        *prometheus.Desc
+	containerCreatedDesc      *prometheus.Desc
+	containerImageAgeDesc     *prometheus.Desc
+	containerHealthScoreDesc  *prometheus.Desc
+	containerRestartingDesc   *prometheus.Desc
+	containerRestartCountDesc *prometheus.Desc
+
+	containerCommandInfoDesc  *prometheus.Desc
+	containerStartLatencyDesc *prometheus.Desc
+	containerSizeRwDesc       *prometheus.Desc
+	containerSizeRootFsDesc   *prometheus.Desc
+	containerConfigHashDesc   *prometheus.Desc
+
+	containerLogDriverDesc   *prometheus.Desc
+	containerLogMaxSizeDesc  *prometheus.Desc
+	containerLogMaxFilesDesc *prometheus.Desc
+
+	containerPrivilegedDesc        *prometheus.Desc
+	containerCapabilitiesAddedDesc *prometheus.Desc
+	containerCapabilityInfoDesc    *prometheus.Desc
+
+	containerGPUCountDesc *prometheus.Desc
+	containerGPUInfoDesc  *prometheus.Desc
+
+	// Keyed by PSI resource, see psiResources.
+	containerPressureRatioDescs   map[string]*prometheus.Desc
+	containerPressureStalledDescs map[string]*prometheus.Desc
+
+	windowCPUUsageDescs    map[string]*prometheus.Desc
+	windowMemoryUsageDescs map[string]*prometheus.Desc
+
+	networkInfoDesc       *prometheus.Desc
+	networkContainersDesc *prometheus.Desc
+
+	cgroupInfoDesc *prometheus.Desc
+
+	buildCacheEntriesDesc     *prometheus.Desc
+	buildCacheReclaimableDesc *prometheus.Desc
+
+	diskUsageUpdatedDesc   *prometheus.Desc
+	imagesDesc             *prometheus.Desc
+	imagesSizeDesc         *prometheus.Desc
+	imagesDanglingDesc     *prometheus.Desc
+	imagesDanglingSizeDesc *prometheus.Desc
+	volumesDesc            *prometheus.Desc
+	volumesSizeDesc        *prometheus.Desc
+	containersRwSizeDesc   *prometheus.Desc
+	buildCacheSizeDesc     *prometheus.Desc
+
+	imageLayersDesc *prometheus.Desc
+
+	swarmNodeInfoDesc  *prometheus.Desc
+	swarmNodeReadyDesc *prometheus.Desc
+
+	swarmServiceDesiredReplicasDesc *prometheus.Desc
+	swarmServiceRunningReplicasDesc *prometheus.Desc
+	swarmServiceTasksDesc           *prometheus.Desc
+)
+
+// initDescs builds the descriptors of the const metrics, with the container
+// descriptors of initContainerDescs. It needs to run once after the flags are
+// parsed, since the labels of docker_up depend on the number of daemons.
+// Help texts must stay the same for a metric name across the process. The
+// counters and gauges the exporter keeps itself, such as
+// scrape_errors_total, are created along with their owners instead.
+func initDescs() {
+	upDesc = newHostDesc("up", "Always 1 when the exporter is able to serve metrics")
+	scrapeSuccessDesc = newHostDesc("scrape_success", "Whether the last collection could list the containers (1) or not (0)")
+	containersTotalDesc = newHostDesc("containers_total", "Number of containers found during the last collection, also when there are none")
+	dockerUpLabels := []string{"docker_host"}
+	if multipleHosts() {
+		dockerUpLabels = nil
+	}
+	dockerUpDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "docker_up"),
+		"Whether the Docker daemon responded to the container list during the last scrape (1) or not (0)",
+		dockerUpLabels, nil,
+	)
+
+	cgroupInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "cgroup_info"),
+		"Cgroup version (1 or 2) and driver (systemd or cgroupfs) of the Docker daemon; always 1",
+		[]string{"version", "driver"}, nil,
+	)
+
+	containersThrottledDesc = newHostDesc("containers_cpu_throttled", "Number of containers that were CPU throttled during their last stats interval")
+
+	containersScrapedDesc = newHostDesc("containers_scraped", "Number of containers whose stats were read during the last collection")
+	scrapeDurationPerContainerDesc = newHostDesc("scrape_duration_per_container_seconds", "Duration of the last collection divided by the number of containers whose stats were read, in seconds")
+	scrapeDurationDesc = newHostDesc("scrape_duration_seconds", "Duration of the last collection in seconds, also when it failed")
+
+	totalCPUUsageDesc = newHostDesc("total_cpu_usage_percent", "Sum of the CPU usage of all containers in percent, where 100 is one fully used host CPU")
+	totalMemoryUsageDesc = newHostDesc("total_memory_usage_bytes", "Sum of the memory usage of all containers in bytes, excluding the inactive page cache")
+	averageCPUUsageDesc = newHostDesc("average_cpu_usage_percent", "Average CPU usage per container in percent, where 100 is one fully used host CPU")
+	averageMemoryUsageDesc = newHostDesc("average_memory_usage_bytes", "Average memory usage per container in bytes, excluding the inactive page cache")
+
+	composeServiceContainersDesc = newComposeServiceDesc("compose_service_containers", "Number of containers of the Compose service")
+	composeServiceCPUUsageDesc = newComposeServiceDesc("compose_service_cpu_usage_percent", "Sum of the CPU usage of the Compose service's containers in percent, where 100 is one fully used host CPU")
+	composeServiceMemoryUsageDesc = newComposeServiceDesc("compose_service_memory_usage_bytes", "Sum of the memory usage of the Compose service's containers in bytes")
+	composeServiceNetworkRxBytesDesc = newComposeServiceDesc("compose_service_network_rx_bytes_total", "Sum of the bytes received by the Compose service's containers on all network interfaces")
+	composeServiceNetworkTxBytesDesc = newComposeServiceDesc("compose_service_network_tx_bytes_total", "Sum of the bytes transmitted by the Compose service's containers on all network interfaces")
+	composeServiceNetworkRxPacketsDesc = newComposeServiceDesc("compose_service_network_rx_packets_total", "Sum of the packets received by the Compose service's containers on all network interfaces")
+	composeServiceNetworkTxPacketsDesc = newComposeServiceDesc("compose_service_network_tx_packets_total", "Sum of the packets transmitted by the Compose service's containers on all network interfaces")
+	composeServiceNetworkRxDroppedDesc = newComposeServiceDesc("compose_service_network_rx_dropped_total", "Sum of the received packets dropped on the Compose service's containers' network interfaces")
+	composeServiceNetworkTxDroppedDesc = newComposeServiceDesc("compose_service_network_tx_dropped_total", "Sum of the transmitted packets dropped on the Compose service's containers' network interfaces")
+
+	networkInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "network_info"),
+		"Docker network information; always 1",
+		[]string{"network_id", "name", "driver", "scope"}, nil,
+	)
+	networkContainersDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "network_containers"),
+		"Number of containers connected to the Docker network",
+		[]string{"network_id", "name"}, nil,
+	)
+
+	buildCacheEntriesDesc = newHostDesc("build_cache_entries", "Number of build cache records")
+	buildCacheReclaimableDesc = newHostDesc("build_cache_reclaimable_bytes", "Size of the build cache records that are neither in use nor shared in bytes")
+
+	diskUsageUpdatedDesc = newHostDesc("disk_usage_last_update_timestamp_seconds", "Unix time of the last successful reading of the Docker disk usage")
+	imagesDesc = newHostDesc("images", "Number of images")
+	imagesSizeDesc = newHostDesc("images_size_bytes", "Size of all image layers in bytes, counting shared layers once")
+	imagesDanglingDesc = newHostDesc("images_dangling", "Number of images without a tag")
+	imagesDanglingSizeDesc = newHostDesc("images_dangling_size_bytes", "Summed size of the images without a tag in bytes, including layers shared with other images")
+	volumesDesc = newHostDesc("volumes", "Number of volumes")
+	volumesSizeDesc = newHostDesc("volumes_size_bytes", "Summed size of the volumes in bytes, only counting volumes of the local driver")
+	containersRwSizeDesc = newHostDesc("containers_rw_size_bytes", "Summed size of the writable layers of all containers in bytes")
+	buildCacheSizeDesc = newHostDesc("build_cache_size_bytes", "Size of all build cache records in bytes")
+
+	imageLayersDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "image_layers"),
+		"Number of filesystem layers of the image",
+		[]string{"image_id", "image"}, nil,
+	)
+
+	swarmNodeInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "swarm_node_info"),
+		"Swarm node information; always 1",
+		[]string{"node_id", "hostname", "role", "availability"}, nil,
+	)
+	swarmNodeReadyDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "swarm_node_ready"),
+		"Whether the swarm node's status is ready (1) or not (0)",
+		[]string{"node_id"}, nil,
+	)
+
+	swarmServiceDesiredReplicasDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "swarm_service_desired_replicas"),
+		"Number of tasks the swarm service should have running",
+		[]string{"service_id", "service"}, nil,
+	)
+	swarmServiceRunningReplicasDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "swarm_service_running_replicas"),
+		"Number of tasks of the swarm service that are running",
+		[]string{"service_id", "service"}, nil,
+	)
+	swarmServiceTasksDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "swarm_service_tasks"),
+		"Number of tasks of the swarm service known to the manager, by state",
+		[]string{"service_id", "service", "state"}, nil,
+	)
+
+	initContainerDescs()
+}
+
+// initContainerDescs builds the descriptors labeled with containerLabelNames,
+// which a config reload changes. Everything reading them must hold the mu of
+// a dockerCollector, which applySettings is called with.
+func initContainerDescs() {
+	cpuUsageDesc = newContainerDesc("cpu_usage_percent", "Container CPU usage over the last stats interval in percent, where 100 is one fully used host CPU")
+	cpuUsageLimitDesc = newContainerDesc("cpu_usage_limit_percent", "Container CPU usage over the last stats interval in percent of its CPU limit, where 100 is at the limit; only for containers with a CPU limit")
+	memoryUsageDesc = newContainerDesc("memory_usage_bytes", "Container memory working set in bytes, as docker stats reports it: usage minus the inactive page cache on Linux, private working set on Windows")
+	memoryMaxSeenDesc = newContainerDesc("memory_usage_max_seen_bytes", "Highest container memory usage in bytes seen by the exporter since the container was last started")
+	memoryCacheDesc = newContainerDesc("memory_cache_bytes", "Page cache of the container in bytes, active and inactive; Linux only")
+	memoryRSSDesc = newContainerDesc("memory_rss_bytes", "Anonymous memory of the container in bytes, e.g. heap and stacks; Linux only")
+	memorySwapDesc = newContainerDesc("memory_swap_bytes", "Swap used by the container in bytes; only on cgroup v1 hosts with swap accounting")
+	memoryUsageRatioDesc = newContainerDesc("memory_usage_ratio", "Container memory usage divided by its memory limit, where 1 is at the limit; only for containers with a memory limit")
+	containerMemoryLimitDesc = newContainerDesc("container_memory_limit_bytes", "Memory limit of the container in bytes, see -metrics.unlimited-as for containers without one")
+	containerPidsLimitDesc = newContainerDesc("container_pids_limit", "Maximum number of processes of the container, see -metrics.unlimited-as for containers without one")
+	cpuKernelModeDesc = newContainerDesc("cpu_usage_kernelmode_seconds_total", "Cumulative container CPU time spent in kernel mode in seconds")
+	cpuUserModeDesc = newContainerDesc("cpu_usage_usermode_seconds_total", "Cumulative container CPU time spent in user mode in seconds")
+
+	cpuPeriodsDesc = newContainerDesc("cpu_periods_total", "Number of CPU quota enforcement periods elapsed, only for containers with a CPU quota")
+	cpuThrottledPeriodsDesc = newContainerDesc("cpu_throttled_periods_total", "Number of CPU quota enforcement periods in which the container was throttled")
+	cpuThrottledTimeDesc = newContainerDesc("cpu_throttled_seconds_total", "Cumulative time the container was CPU throttled in seconds")
+
+	blkioReadOpsDesc = newContainerDesc("blkio_read_ops_total", "Cumulative number of block I/O read operations of the container, summed over all devices")
+	blkioWriteOpsDesc = newContainerDesc("blkio_write_ops_total", "Cumulative number of block I/O write operations of the container, summed over all devices")
+	blkioDeviceReadBytesDesc = newContainerDesc("blkio_device_read_bytes_total", "Cumulative number of bytes read by the container from the device", "device")
+	blkioDeviceWriteBytesDesc = newContainerDesc("blkio_device_write_bytes_total", "Cumulative number of bytes written by the container to the device", "device")
+
+	containerStatsSampleTimestampDesc = newContainerDesc("container_stats_sample_timestamp_seconds", "Unix time of the streamed stats sample the container's stats metrics are from, only with -collector.stats-streams")
+
+	containerStartTimeDesc = newContainerDesc("container_start_time_seconds", "Unix time the container was last started at")
+	containerExitCodeDesc = newContainerDesc("container_exit_code", "Exit code of the last run of a container that is not running")
+	containerHealthStatusDesc = newContainerDesc("container_health_status", "Healthcheck status of the container: starting, healthy or unhealthy; always 1, only for containers with a healthcheck", "status")
+
+	blkioReadBytesDesc = newContainerDesc("blkio_read_bytes_total", "Cumulative number of bytes read by the container from block devices, summed over all devices")
+	blkioWriteBytesDesc = newContainerDesc("blkio_write_bytes_total", "Cumulative number of bytes written by the container to block devices, summed over all devices")
+	networkRxBytesDesc = newContainerDesc("network_rx_bytes_total", "Cumulative number of bytes received by the container on the network interface", "interface")
+	networkTxBytesDesc = newContainerDesc("network_tx_bytes_total", "Cumulative number of bytes transmitted by the container on the network interface", "interface")
+	networkRxPacketsDesc = newContainerDesc("network_rx_packets_total", "Cumulative number of packets received by the container on the network interface", "interface")
+	networkTxPacketsDesc = newContainerDesc("network_tx_packets_total", "Cumulative number of packets transmitted by the container on the network interface", "interface")
+	networkRxDroppedDesc = newContainerDesc("network_rx_dropped_total", "Cumulative number of received packets dropped on the container's network interface", "interface")
+	networkTxDroppedDesc = newContainerDesc("network_tx_dropped_total", "Cumulative number of transmitted packets dropped on the container's network interface", "interface")
+
+	containerStateDesc = newContainerDesc("container_state", "State of the container as listed by Docker: created, restarting, running, paused, exited, removing or dead; always 1", "state")
+	containerCreatedDesc = newContainerDesc("container_created_timestamp_seconds", "Unix time the container was created at")
+	containerImageAgeDesc = newContainerDesc("container_image_age_seconds", "Time since the container's image was created in seconds")
+	containerHealthScoreDesc = newContainerDesc("container_health_score", "Weighted rollup of CPU and memory headroom, restart recency and healthcheck status, from 0 (worst) to 100 (best); unitless")
+	containerRestartingDesc = newContainerDesc("container_restarting", "Whether the container is currently restarting (1) or not (0)")
+	containerRestartCountDesc = newContainerDesc("container_restart_count", "Number of times the container has been restarted by Docker")
+
+	containerCommandInfoDesc = newContainerDesc("container_command_info", "Command the container was started with, truncated to -collector.command-info.max-length bytes; always 1", "command")
+	containerStartLatencyDesc = newContainerDesc("container_start_latency_seconds", "Time between the creation of the container and its last start in seconds")
+	containerSizeRwDesc = newContainerDesc("container_size_rw_bytes", "Size of the files created or changed in the container's writable layer in bytes")
+	containerSizeRootFsDesc = newContainerDesc("container_size_root_fs_bytes", "Total size of all the files in the container's root filesystem in bytes")
+	containerConfigHashDesc = newContainerDesc("container_config_hash", "FNV-1a hash of the container's image, environment variable count, labels and mounts; unitless")
+
+	containerLogDriverDesc = newContainerDesc("container_log_driver", "Logging driver configured for the container; always 1", "driver")
+	containerLogMaxSizeDesc = newContainerDesc("container_log_max_size_bytes", "Size in bytes at which a container log file is rotated, from the max-size log option")
+	containerLogMaxFilesDesc = newContainerDesc("container_log_max_files", "Maximum number of rotated container log files kept, from the max-file log option")
+
+	containerPrivilegedDesc = newContainerDesc("container_privileged", "Whether the container runs in privileged mode (1) or not (0)")
+	containerCapabilitiesAddedDesc = newContainerDesc("container_capabilities_added", "Number of Linux capabilities added to the container with --cap-add")
+	containerCapabilityInfoDesc = newContainerDesc("container_capability_info", "Linux capability added to the container with --cap-add; always 1", "capability")
+
+	containerGPUCountDesc = newContainerDesc("container_gpu_count", "Number of GPUs requested by the container, -1 when all GPUs were requested")
+	containerGPUInfoDesc = newContainerDesc("container_gpu_info", "GPU device request of the container; always 1", "driver", "device_ids")
+
+	containerPressureRatioDescs = map[string]*prometheus.Desc{}
+	containerPressureStalledDescs = map[string]*prometheus.Desc{}
+	for _, resource := range psiResources {
+		containerPressureRatioDescs[resource] = newContainerDesc("container_"+resource+"_pressure_ratio",
+			"Ratio (0-1) of the last 10 seconds the container's tasks were stalled on "+resource+", from the cgroup PSI avg10", "kind")
+		containerPressureStalledDescs[resource] = newContainerDesc("container_"+resource+"_pressure_stalled_seconds_total",
+			"Cumulative time the container's tasks were stalled on "+resource+" in seconds, from the cgroup PSI", "kind")
+	}
+
+	windowCPUUsageDescs = map[string]*prometheus.Desc{}
+	windowMemoryUsageDescs = map[string]*prometheus.Desc{}
+	for _, stat := range windowStats {
+		windowCPUUsageDescs[stat] = newContainerDesc("cpu_usage_percent_"+stat,
+			"The "+stat+" of the container CPU usage in percent over -metrics.window, from the -collector.windowed-stats sampler")
+		windowMemoryUsageDescs[stat] = newContainerDesc("memory_usage_bytes_"+stat,
+			"The "+stat+" of the container memory usage in bytes over -metrics.window, from the -collector.windowed-stats sampler")
+	}
+
+	cpuUsageSecondsDesc = nil
+	memoryUsageRawDesc = nil
+	if *metricsCompat == "cadvisor" {
+		initCadvisorDescs()
+	}
+}
+
+// newHostDesc creates a descriptor for a host-wide metric, without labels.
+func newHostDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, nil, nil)
+}
+
+// newComposeServiceDesc creates a descriptor for a metric aggregated per
+// Compose service.
+func newComposeServiceDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, []string{"compose_project", "compose_service"}, nil)
+}
+
+// newContainerDesc creates a descriptor labeled with containerLabelNames,
+// followed by any metric specific labels.
+func newContainerDesc(name, help string, extraLabels ...string) *prometheus.Desc {
+	labels := append(append([]string{}, containerLabelNames...), extraLabels...)
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", name),
+		help,
+		labels, nil,
+	)
+}
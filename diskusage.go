@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	collectDiskUsage  = flag.Bool("collector.disk-usage", false, "Export Docker disk usage, as reported by docker system df, including the writable layer size of every collected container.")
+	diskUsageInterval = flag.Duration("collector.disk-usage.interval", 5*time.Minute, "Interval at which the disk usage is read in the background. Scrapes get the last reading.")
+)
+
+// diskUsageCollector exports the data of the Docker system df endpoint. It is
+// expensive for the daemon, so it is read in the background every
+// -collector.disk-usage.interval rather than on every scrape.
+type diskUsageCollector struct {
+	dockerClient *client.Client
+	limiter      *rate.Limiter
+
+	mu sync.Mutex
+	// du is nil until the first reading succeeded.
+	du        *types.DiskUsage
+	updatedAt time.Time
+	// sizesRw holds the writable layer size of the containers in du by ID.
+	sizesRw map[string]int64
+}
+
+func newDiskUsageCollector(cli *client.Client, limiter *rate.Limiter) *diskUsageCollector {
+	return &diskUsageCollector{
+		dockerClient: cli,
+		limiter:      limiter,
+	}
+}
+
+// start reads the disk usage in the background right away and then every
+// interval. A reading that takes longer than interval is given up on.
+func (duc *diskUsageCollector) start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			duc.refresh(interval)
+			<-ticker.C
+		}
+	}()
+}
+
+func (duc *diskUsageCollector) refresh(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := duc.limiter.Wait(ctx); err != nil {
+		log.Println("Failed to get disk usage:", err)
+		return
+	}
+	du, err := duc.dockerClient.DiskUsage(ctx, types.DiskUsageOptions{})
+	if err != nil {
+		log.Println("Failed to get disk usage:", err)
+		return
+	}
+
+	sizesRw := make(map[string]int64, len(du.Containers))
+	for _, container := range du.Containers {
+		sizesRw[container.ID] = container.SizeRw
+	}
+
+	duc.mu.Lock()
+	duc.du, duc.updatedAt, duc.sizesRw = &du, time.Now(), sizesRw
+	duc.mu.Unlock()
+}
+
+// containerSizeRw returns the writable layer size of a container from the
+// last reading, if the container was in it.
+func (duc *diskUsageCollector) containerSizeRw(containerID string) (int64, bool) {
+	duc.mu.Lock()
+	defer duc.mu.Unlock()
+	size, ok := duc.sizesRw[containerID]
+	return size, ok
+}
+
+func (duc *diskUsageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- diskUsageUpdatedDesc
+	ch <- imagesDesc
+	ch <- imagesSizeDesc
+	ch <- imagesDanglingDesc
+	ch <- imagesDanglingSizeDesc
+	ch <- volumesDesc
+	ch <- volumesSizeDesc
+	ch <- containersRwSizeDesc
+	ch <- buildCacheEntriesDesc
+	ch <- buildCacheSizeDesc
+	ch <- buildCacheReclaimableDesc
+}
+
+func (duc *diskUsageCollector) Collect(ch chan<- prometheus.Metric) {
+	duc.mu.Lock()
+	du, updatedAt := duc.du, duc.updatedAt
+	duc.mu.Unlock()
+	if du == nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(diskUsageUpdatedDesc, prometheus.GaugeValue, float64(updatedAt.UnixNano())/1e9)
+
+	// LayersSize counts layers shared by several images once, unlike the
+	// sizes of the individual images.
+	var dangling int
+	var danglingSize int64
+	for _, image := range du.Images {
+		if isDangling(image) {
+			dangling++
+			danglingSize += image.Size
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(imagesDesc, prometheus.GaugeValue, float64(len(du.Images)))
+	ch <- prometheus.MustNewConstMetric(imagesSizeDesc, prometheus.GaugeValue, float64(du.LayersSize))
+	ch <- prometheus.MustNewConstMetric(imagesDanglingDesc, prometheus.GaugeValue, float64(dangling))
+	ch <- prometheus.MustNewConstMetric(imagesDanglingSizeDesc, prometheus.GaugeValue, float64(danglingSize))
+
+	// Volumes of other drivers than local have a size of -1.
+	var volumesSize int64
+	for _, volume := range du.Volumes {
+		if volume.UsageData != nil && volume.UsageData.Size > 0 {
+			volumesSize += volume.UsageData.Size
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(volumesDesc, prometheus.GaugeValue, float64(len(du.Volumes)))
+	ch <- prometheus.MustNewConstMetric(volumesSizeDesc, prometheus.GaugeValue, float64(volumesSize))
+
+	var rwSize int64
+	for _, container := range du.Containers {
+		rwSize += container.SizeRw
+	}
+	ch <- prometheus.MustNewConstMetric(containersRwSizeDesc, prometheus.GaugeValue, float64(rwSize))
+
+	// Daemons from before BuildKit don't return any build cache data at
+	// all, as opposed to an empty list.
+	if du.BuildCache != nil {
+		var size, reclaimable int64
+		for _, entry := range du.BuildCache {
+			size += entry.Size
+			if !entry.InUse && !entry.Shared {
+				reclaimable += entry.Size
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(buildCacheEntriesDesc, prometheus.GaugeValue, float64(len(du.BuildCache)))
+		ch <- prometheus.MustNewConstMetric(buildCacheSizeDesc, prometheus.GaugeValue, float64(size))
+		ch <- prometheus.MustNewConstMetric(buildCacheReclaimableDesc, prometheus.GaugeValue, float64(reclaimable))
+	}
+}
+
+// isDangling reports whether an image has no tag, like the ones left behind
+// when a tag is moved to a newer build.
+func isDangling(image *types.ImageSummary) bool {
+	for _, tag := range image.RepoTags {
+		if tag != "<none>:<none>" {
+			return false
+		}
+	}
+	return true
+}
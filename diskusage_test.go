@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDiskUsageContainerSizeRw(t *testing.T) {
+	setDockerHosts(t, newFakeDaemon(t, "a1", "a2").host())
+	setTestFlag(t, "collector.disk-usage", "true")
+	reg := registerDaemons(t)
+
+	// The disk usage is read in the background.
+	var sizes map[string]float64
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if sizes = seriesByHost(gather(t, reg), "docker_exporter_container_size_rw_bytes")[""]; len(sizes) > 0 {
+			break
+		}
+	}
+	want := map[string]float64{"a1": 1000, "a2": 2000}
+	for name, size := range want {
+		if sizes[name] != size {
+			t.Errorf("container_size_rw_bytes of %s = %v, want %v", name, sizes[name], size)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/docker/docker/client"
+	"golang.org/x/time/rate"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var (
+	bearerToken     = flag.String("docker.bearer-token", "", "Bearer token sent in the Authorization header of every Docker API request.")
+	bearerTokenFile = flag.String("docker.bearer-token-file", "", "File to read the Docker API bearer token from. Mutually exclusive with -docker.bearer-token.")
+	tlsCA           = flag.String("docker.tls-ca", "", "CA certificate bundle used to verify the Docker daemon's certificate. Defaults to the system pool.")
+	tlsCert         = flag.String("docker.tls-cert", "", "Client certificate for TLS connections to the Docker daemon.")
+	tlsKey          = flag.String("docker.tls-key", "", "Client certificate key for TLS connections to the Docker daemon.")
+	tlsInsecure     = flag.Bool("docker.tls-insecure", false, "Skip verification of the Docker daemon's certificate. Only meant for testing.")
+	rateLimit       = flag.Float64("docker.rate-limit", 0, "Maximum number of Docker API calls per second. 0 means unlimited.")
+	userAgent       = flag.String("docker.user-agent", "docker_exporter/"+version, "User-Agent sent with every Docker API request.")
+	apiVersion      = flag.String("docker.api-version", "", "Docker API version to use, e.g. 1.41. Empty negotiates the highest version both the client and the daemon support.")
+)
+
+// dockerHosts holds every -docker.host given.
+var dockerHosts = stringsFlag{}
+
+// newDockerClient creates a Docker API client for host from the command line
+// flags. An empty host falls back to $DOCKER_HOST.
+func newDockerClient(host string) (*client.Client, error) {
+	tlsConfig, err := loadTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	return newDockerClientWithTLS(host, tlsConfig)
+}
+
+// newDockerClientWithTLS creates a Docker API client for host that uses
+// tlsConfig, which may be nil, instead of the -docker.tls-* flags.
+func newDockerClientWithTLS(host string, tlsConfig *tls.Config) (*client.Client, error) {
+	opts := clientOpts(host, tlsConfig)
+
+	token, err := loadBearerToken()
+	if err != nil {
+		return nil, err
+	}
+	headers := clientHeaders()
+	if token != "" {
+		headers["Authorization"] = "Bearer " + token
+	}
+	opts = append(opts, client.WithHTTPHeaders(headers))
+
+	return client.NewClientWithOpts(opts...)
+}
+
+// clientOpts returns the options every Docker client is created with, apart
+// from the headers: the transport for tlsConfig, which may be nil, the API
+// version and the host, falling back to $DOCKER_HOST if host is empty.
+func clientOpts(host string, tlsConfig *tls.Config) []client.Opt {
+	var opts []client.Opt
+	if tlsConfig != nil {
+		// This has to come before the host options, which set up the dialer
+		// on whatever transport the client has at that point.
+		opts = append(opts, client.WithHTTPClient(&http.Client{
+			Transport:     &http.Transport{TLSClientConfig: tlsConfig},
+			CheckRedirect: client.CheckRedirect,
+		}))
+	}
+	opts = append(opts, client.WithHostFromEnv(), apiVersionOpt())
+	if host != "" {
+		opts = append(opts, client.WithHost(host))
+	}
+	return opts
+}
+
+// apiVersionOpt returns the client option for -docker.api-version.
+func apiVersionOpt() client.Opt {
+	if *apiVersion == "" {
+		return client.WithAPIVersionNegotiation()
+	}
+	return client.WithVersion(*apiVersion)
+}
+
+// clientHeaders returns the custom headers added to every request a Docker
+// client makes. WithHTTPHeaders replaces rather than merges them, so all of
+// them have to be set at once.
+func clientHeaders() map[string]string {
+	return map[string]string{"User-Agent": *userAgent}
+}
+
+// loadTLSConfig builds the TLS config for the Docker daemon connection, or nil
+// when TLS isn't configured. All files are read and parsed here so that a bad
+// path or certificate fails at startup rather than on the first scrape.
+func loadTLSConfig() (*tls.Config, error) {
+	if *tlsCA == "" && *tlsCert == "" && *tlsKey == "" && !*tlsInsecure {
+		return nil, nil
+	}
+	config, err := newTLSConfig(*tlsCA, *tlsCert, *tlsKey, *tlsInsecure)
+	if err != nil {
+		return nil, fmt.Errorf("-docker.tls-*: %w", err)
+	}
+	return config, nil
+}
+
+// newTLSConfig builds a TLS config from a CA bundle and a client certificate
+// and key, each of which may be empty.
+func newTLSConfig(ca, cert, key string, insecure bool) (*tls.Config, error) {
+	if (cert == "") != (key == "") {
+		return nil, errors.New("client certificate and key must be given together")
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA certificates: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificates found in CA file %s", ca)
+		}
+		config.RootCAs = pool
+	}
+
+	if cert != "" {
+		certificate, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate and key: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	if insecure {
+		log.Println("WARNING: TLS verification is disabled, the Docker daemon's certificate is NOT verified. Do not use this in production.")
+		config.InsecureSkipVerify = true
+	}
+
+	return config, nil
+}
+
+// newRateLimiter returns the limiter shared by all Docker API calls, as
+// configured by -docker.rate-limit.
+func newRateLimiter() *rate.Limiter {
+	if *rateLimit <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	burst := int(math.Ceil(*rateLimit))
+	return rate.NewLimiter(rate.Limit(*rateLimit), burst)
+}
+
+// loadBearerToken returns the configured bearer token, if any. The token
+// itself is never included in errors or logs.
+func loadBearerToken() (string, error) {
+	if *bearerTokenFile == "" {
+		return *bearerToken, nil
+	}
+	if *bearerToken != "" {
+		return "", errors.New("-docker.bearer-token and -docker.bearer-token-file are mutually exclusive")
+	}
+
+	data, err := os.ReadFile(*bearerTokenFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// dockerUpLabelValues returns the label values of docker_up. With several
+// hosts the host is already a constant label of every metric.
+func dockerUpLabelValues(cli *client.Client) []string {
+	if multipleHosts() {
+		return nil
+	}
+	return []string{cli.DaemonHost()}
+}
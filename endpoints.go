@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/docker/docker/client"
+	"gopkg.in/yaml.v3"
+	"os"
+)
+
+var (
+	dockerEndpointsFile = flag.String("docker.endpoints-file", "", "YAML file listing Docker daemons to collect from, each with its own host and TLS files. Combined with -docker.host, every daemon is labeled with docker_host.")
+)
+
+// dockerEndpoint is a Docker daemon listed in -docker.endpoints-file.
+type dockerEndpoint struct {
+	Host string `yaml:"host"`
+	// CA, Cert and Key are the TLS files of the endpoint, which replace the
+	// -docker.tls-* flags for it.
+	CA       string `yaml:"ca"`
+	Cert     string `yaml:"cert"`
+	Key      string `yaml:"key"`
+	Insecure bool   `yaml:"insecure"`
+}
+
+// dockerEndpoints holds the endpoints read by loadDockerEndpoints.
+var dockerEndpoints []dockerEndpoint
+
+// loadDockerEndpoints reads -docker.endpoints-file, if any, into
+// dockerEndpoints.
+func loadDockerEndpoints() error {
+	if *dockerEndpointsFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(*dockerEndpointsFile)
+	if err != nil {
+		return err
+	}
+
+	var endpoints []dockerEndpoint
+	if err := yaml.Unmarshal(data, &endpoints); err != nil {
+		return fmt.Errorf("parsing %s: %w", *dockerEndpointsFile, err)
+	}
+	seen := map[string]bool{}
+	for _, host := range dockerHosts {
+		seen[host] = true
+	}
+	for _, e := range endpoints {
+		if e.Host == "" {
+			return fmt.Errorf("endpoint without host in %s", *dockerEndpointsFile)
+		}
+		if seen[e.Host] {
+			return fmt.Errorf("duplicate endpoint %s in %s", e.Host, *dockerEndpointsFile)
+		}
+		seen[e.Host] = true
+	}
+	if len(endpoints) == 0 {
+		return errors.New("no endpoints in " + *dockerEndpointsFile)
+	}
+	dockerEndpoints = endpoints
+	return nil
+}
+
+// newClient creates a Docker API client for the endpoint. Endpoints without
+// TLS files fall back to the -docker.tls-* flags.
+func (e dockerEndpoint) newClient() (*client.Client, error) {
+	if e.CA == "" && e.Cert == "" && e.Key == "" && !e.Insecure {
+		return newDockerClient(e.Host)
+	}
+
+	tlsConfig, err := newTLSConfig(e.CA, e.Cert, e.Key, e.Insecure)
+	if err != nil {
+		return nil, fmt.Errorf("endpoint %s: %w", e.Host, err)
+	}
+	return newDockerClientWithTLS(e.Host, tlsConfig)
+}
+
+// multipleHosts reports whether the exporter collects from several
+// -docker.host or -docker.endpoints-file daemons, which are then labeled with
+// docker_host.
+func multipleHosts() bool {
+	return len(dockerHosts)+len(dockerEndpoints) > 1
+}
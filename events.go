@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"log"
+	"time"
+)
+
+var (
+	collectEvents = flag.Bool("collector.events", false, "Follow the Docker events stream in the background and export counters of image pulls and container events.")
+)
+
+// containerEvents are the container events counted by -collector.events.
+var containerEvents = []string{"start", "die", "oom", "restart", "kill"}
+
+const (
+	eventsMinBackoff = time.Second
+	eventsMaxBackoff = 30 * time.Second
+)
+
+// eventStream follows the events of a Docker daemon that match filters. The
+// stream is reopened whenever it ends, starting from the last event seen so
+// that no event is missed or handled twice.
+type eventStream struct {
+	dockerClient *client.Client
+	limiter      *rate.Limiter
+	filters      filters.Args
+	handle       func(events.Message)
+
+	// lastEvent is the time of the last event seen, in Unix nanoseconds.
+	lastEvent int64
+}
+
+// start follows the events stream until the process exits.
+func (s *eventStream) start() {
+	go func() {
+		backoff := eventsMinBackoff
+		for {
+			connected := time.Now()
+			err := s.watch()
+			if time.Since(connected) > eventsMaxBackoff {
+				backoff = eventsMinBackoff
+			}
+			log.Println("Docker events stream of", s.dockerClient.DaemonHost(), "ended, reconnecting in", backoff, ":", err)
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > eventsMaxBackoff {
+				backoff = eventsMaxBackoff
+			}
+		}
+	}()
+}
+
+// watch reads the events stream until it fails.
+func (s *eventStream) watch() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := s.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	options := types.EventsOptions{Filters: s.filters}
+	if s.lastEvent > 0 {
+		// Since is inclusive, so resume just after the last event.
+		next := s.lastEvent + 1
+		options.Since = fmt.Sprintf("%d.%09d", next/int64(time.Second), next%int64(time.Second))
+	}
+
+	messages, errs := s.dockerClient.Events(ctx, options)
+	for {
+		select {
+		case msg := <-messages:
+			s.lastEvent = msg.TimeNano
+			s.handle(msg)
+		case err := <-errs:
+			return err
+		}
+	}
+}
+
+// eventWatcher counts the image pulls and container events of a Docker
+// daemon.
+type eventWatcher struct {
+	stream          *eventStream
+	imagePulls      *prometheus.CounterVec
+	containerEvents *prometheus.CounterVec
+}
+
+// newEventWatcher creates an eventWatcher. Its counters are registered by the
+// caller.
+func newEventWatcher(cli *client.Client, limiter *rate.Limiter) *eventWatcher {
+	w := &eventWatcher{
+		imagePulls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "image_pulls_total",
+			Help:      "Number of completed image pulls seen on the Docker events stream since the exporter started",
+		}, []string{"image"}),
+		containerEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "container_events_total",
+			Help:      "Number of container events seen on the Docker events stream since the exporter started, by event and container name",
+		}, []string{"event", "name"}),
+	}
+
+	// Filters with the same key are ORed and different keys ANDed, which is
+	// fine as long as no event name is shared by images and containers.
+	args := filters.NewArgs(
+		filters.Arg("type", string(events.ImageEventType)),
+		filters.Arg("type", string(events.ContainerEventType)),
+		filters.Arg("event", "pull"),
+	)
+	for _, event := range containerEvents {
+		args.Add("event", event)
+	}
+	w.stream = &eventStream{
+		dockerClient: cli,
+		limiter:      limiter,
+		filters:      args,
+		handle:       w.handle,
+	}
+	return w
+}
+
+// start follows the events stream until the process exits.
+func (w *eventWatcher) start() {
+	w.stream.start()
+}
+
+func (w *eventWatcher) handle(msg events.Message) {
+	switch msg.Type {
+	case events.ImageEventType:
+		// The daemon only reports a pull once it has completed, there are
+		// no events for a pull starting or failing. That is why there is no
+		// image_pulls_in_progress gauge: the only progress reports are in
+		// the response of the client that started the pull, which the
+		// exporter never sees.
+		if msg.Action == "pull" {
+			w.imagePulls.WithLabelValues(msg.Actor.ID).Inc()
+		}
+	case events.ContainerEventType:
+		w.containerEvents.WithLabelValues(msg.Action, msg.Actor.Attributes["name"]).Inc()
+	}
+}
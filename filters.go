@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"regexp"
+	"strings"
+)
+
+var (
+	includeName = flag.String("docker.include-name", "", "Only collect containers whose name matches this regular expression, anchored at both ends. Empty collects all containers.")
+	excludeName = flag.String("docker.exclude-name", "", "Don't collect containers whose name matches this regular expression, anchored at both ends.")
+)
+
+// includeLabels and excludeLabels hold every -docker.include-label and
+// -docker.exclude-label given, as key or key=value.
+var (
+	includeLabels = stringsFlag{}
+	excludeLabels = stringsFlag{}
+)
+
+// labelMatcher matches containers that have a label, with a particular value
+// unless any value is allowed.
+type labelMatcher struct {
+	key, value string
+	anyValue   bool
+}
+
+func parseLabelMatcher(s string) labelMatcher {
+	key, value, ok := strings.Cut(s, "=")
+	return labelMatcher{key: key, value: value, anyValue: !ok}
+}
+
+func (m labelMatcher) matches(labels map[string]string) bool {
+	value, ok := labels[m.key]
+	return ok && (m.anyValue || value == m.value)
+}
+
+// containerFilter selects the containers to collect by name and label. A
+// container has to match every include and none of the excludes.
+type containerFilter struct {
+	includeName   *regexp.Regexp
+	excludeName   *regexp.Regexp
+	includeLabels []labelMatcher
+	excludeLabels []labelMatcher
+}
+
+// newContainerFilter compiles the filter flags.
+func newContainerFilter() (*containerFilter, error) {
+	f := &containerFilter{}
+	var err error
+	if f.includeName, err = compileAnchored(*includeName); err != nil {
+		return nil, fmt.Errorf("parsing -docker.include-name: %w", err)
+	}
+	if f.excludeName, err = compileAnchored(*excludeName); err != nil {
+		return nil, fmt.Errorf("parsing -docker.exclude-name: %w", err)
+	}
+	for _, s := range includeLabels {
+		f.includeLabels = append(f.includeLabels, parseLabelMatcher(s))
+	}
+	for _, s := range excludeLabels {
+		f.excludeLabels = append(f.excludeLabels, parseLabelMatcher(s))
+	}
+	return f, nil
+}
+
+// compileAnchored compiles expr anchored at both ends, or returns nil for an
+// empty expr.
+func compileAnchored(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+func (f *containerFilter) matches(container types.Container) bool {
+	name := containerName(container)
+	if f.includeName != nil && !f.includeName.MatchString(name) {
+		return false
+	}
+	if f.excludeName != nil && f.excludeName.MatchString(name) {
+		return false
+	}
+	for _, m := range f.includeLabels {
+		if !m.matches(container.Labels) {
+			return false
+		}
+	}
+	for _, m := range f.excludeLabels {
+		if m.matches(container.Labels) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"github.com/docker/docker/api/types/container"
+	"github.com/prometheus/client_golang/prometheus"
+	"strings"
+)
+
+var (
+	collectGPU = flag.Bool("collector.gpu", false, "Export the GPUs requested by containers started with --gpus.")
+)
+
+// collectGPUMetrics emits the GPU allocation of a container from its device
+// requests. It is metadata from inspect, not live GPU utilization. Containers
+// without GPU requests produce no metrics.
+func collectGPUMetrics(ch chan<- prometheus.Metric, requests []container.DeviceRequest, labels []string) {
+	count := 0
+	found := false
+	for _, request := range requests {
+		if !isGPURequest(request) {
+			continue
+		}
+		found = true
+
+		// count stays -1 once a request asked for all GPUs.
+		switch {
+		case len(request.DeviceIDs) > 0 && count >= 0:
+			count += len(request.DeviceIDs)
+		case request.Count < 0:
+			// --gpus all
+			count = -1
+		case count >= 0:
+			count += request.Count
+		}
+
+		deviceIDs := strings.Join(request.DeviceIDs, ",")
+		if deviceIDs == "" && request.Count < 0 {
+			deviceIDs = "all"
+		}
+		ch <- prometheus.MustNewConstMetric(containerGPUInfoDesc, prometheus.GaugeValue, 1, append(labels, request.Driver, deviceIDs)...)
+	}
+
+	if found {
+		ch <- prometheus.MustNewConstMetric(containerGPUCountDesc, prometheus.GaugeValue, float64(count), labels...)
+	}
+}
+
+// isGPURequest reports whether a device request asks for GPUs. The Docker CLI
+// sets the "gpu" capability for --gpus, while older tooling only sets the
+// nvidia driver.
+func isGPURequest(request container.DeviceRequest) bool {
+	if request.Driver == "nvidia" {
+		return true
+	}
+	for _, capabilities := range request.Capabilities {
+		for _, capability := range capabilities {
+			if capability == "gpu" {
+				return true
+			}
+		}
+	}
+	return false
+}
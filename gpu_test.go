@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/docker/docker/api/types/container"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"testing"
+)
+
+func TestGPUCount(t *testing.T) {
+	initDescs()
+	gpu := [][]string{{"gpu"}}
+	all := container.DeviceRequest{Count: -1, Capabilities: gpu}
+	ids := container.DeviceRequest{DeviceIDs: []string{"0", "1"}, Capabilities: gpu}
+	two := container.DeviceRequest{Count: 2, Capabilities: gpu}
+	tests := []struct {
+		name     string
+		requests []container.DeviceRequest
+		want     float64
+	}{
+		{"device ids", []container.DeviceRequest{ids}, 2},
+		{"count", []container.DeviceRequest{two}, 2},
+		{"device ids and count", []container.DeviceRequest{ids, two}, 4},
+		{"all", []container.DeviceRequest{all}, -1},
+		{"all then device ids", []container.DeviceRequest{all, ids}, -1},
+		{"device ids then all", []container.DeviceRequest{ids, all}, -1},
+		{"all then count", []container.DeviceRequest{all, two}, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := make(chan prometheus.Metric, 10)
+			collectGPUMetrics(ch, tt.requests, make([]string, len(containerLabelNames)))
+			close(ch)
+
+			found := false
+			for m := range ch {
+				if m.Desc() != containerGPUCountDesc {
+					continue
+				}
+				found = true
+				var pb dto.Metric
+				if err := m.Write(&pb); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+				if got := pb.GetGauge().GetValue(); got != tt.want {
+					t.Errorf("container_gpu_count = %v, want %v", got, tt.want)
+				}
+			}
+			if !found {
+				t.Error("container_gpu_count not exported")
+			}
+		})
+	}
+}
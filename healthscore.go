@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"time"
+)
+
+var (
+	collectHealthScore  = flag.Bool("collector.health-score", false, "Export container_health_score, a 0-100 rollup of CPU and memory headroom, restart recency and healthcheck status.")
+	healthCPUWeight     = flag.Float64("collector.health-score.cpu-weight", 1, "Weight of the CPU headroom in the health score.")
+	healthMemoryWeight  = flag.Float64("collector.health-score.memory-weight", 1, "Weight of the memory headroom in the health score.")
+	healthRestartWeight = flag.Float64("collector.health-score.restart-weight", 1, "Weight of the restart recency in the health score.")
+	healthCheckWeight   = flag.Float64("collector.health-score.healthcheck-weight", 1, "Weight of the healthcheck status in the health score.")
+	healthRestartWindow = flag.Duration("collector.health-score.restart-window", time.Hour, "Time after a restart until the restart no longer lowers the health score.")
+)
+
+// healthScore combines the inputs that are available for a container into a
+// score from 0 to 100, as the weighted average of their sub-scores from 0 to
+// 1. ok is false when none of them are available.
+func healthScore(info *types.ContainerJSON, metrics *containerMetrics, now time.Time) (score float64, ok bool) {
+	var sum, weights float64
+	add := func(subScore, weight float64) {
+		if weight > 0 {
+			sum += clamp01(subScore) * weight
+			weights += weight
+		}
+	}
+
+	var hostConfig *container.HostConfig
+	var state *types.ContainerState
+	if info != nil {
+		hostConfig, state = info.HostConfig, info.State
+	}
+
+	if metrics != nil && hostConfig != nil {
+		if cpus := cpuLimit(hostConfig); cpus > 0 {
+			add(1-metrics.cpuUsagePercent/(cpus*100), *healthCPUWeight)
+		}
+		if hostConfig.Memory > 0 {
+			add(1-float64(metrics.memoryUsageBytes)/float64(hostConfig.Memory), *healthMemoryWeight)
+		}
+	}
+
+	if state != nil {
+		if info.RestartCount == 0 {
+			add(1, *healthRestartWeight)
+		} else if startedAt, err := time.Parse(time.RFC3339Nano, state.StartedAt); err == nil && *healthRestartWindow > 0 {
+			add(now.Sub(startedAt).Seconds()/healthRestartWindow.Seconds(), *healthRestartWeight)
+		}
+
+		if state.Health != nil {
+			switch state.Health.Status {
+			case types.Healthy:
+				add(1, *healthCheckWeight)
+			case types.Starting:
+				add(0.5, *healthCheckWeight)
+			case types.Unhealthy:
+				add(0, *healthCheckWeight)
+			}
+		}
+	}
+
+	if weights == 0 {
+		return 0, false
+	}
+	return 100 * sum / weights, true
+}
+
+func clamp01(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
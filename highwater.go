@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+// highWaterMark is the highest memory usage seen for one container series.
+type highWaterMark struct {
+	containerID string
+	startedAt   string
+	bytes       uint64
+}
+
+// memoryHighWater tracks the memory high-water mark of every container series,
+// keyed by its label values. A mark is reset when the container behind the
+// series was started again, and dropped once the container isn't listed
+// anymore.
+type memoryHighWater struct {
+	mu    sync.Mutex
+	marks map[string]highWaterMark
+}
+
+func newMemoryHighWater() *memoryHighWater {
+	return &memoryHighWater{marks: map[string]highWaterMark{}}
+}
+
+// update records the memory usage of results and sets their memoryMaxSeen.
+// listed holds the IDs of all listed containers. Marks are kept while their
+// container is listed, so that a failed or skipped stats read doesn't reset
+// them.
+func (hw *memoryHighWater) update(results []*containerResult, listed map[string]bool) {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+
+	for _, result := range results {
+		if result.metrics == nil {
+			continue
+		}
+		key := strings.Join(result.labels, "\xff")
+
+		// The start time is unknown when inspecting the container failed,
+		// which is no reason to assume a restart.
+		startedAt := ""
+		if result.info != nil && result.info.State != nil {
+			startedAt = result.info.State.StartedAt
+		}
+
+		mark, ok := hw.marks[key]
+		if !ok || startedAt != "" && mark.startedAt != "" && mark.startedAt != startedAt {
+			mark = highWaterMark{}
+		}
+		mark.containerID = result.container.ID
+		if startedAt != "" {
+			mark.startedAt = startedAt
+		}
+		if result.metrics.memoryUsageBytes > mark.bytes {
+			mark.bytes = result.metrics.memoryUsageBytes
+		}
+		hw.marks[key] = mark
+		result.memoryMaxSeen = mark.bytes
+	}
+
+	for key, mark := range hw.marks {
+		if !listed[mark.containerID] {
+			delete(hw.marks, key)
+		}
+	}
+}
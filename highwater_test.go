@@ -0,0 +1,58 @@
+package main
+
+import (
+	"github.com/docker/docker/api/types"
+	"testing"
+)
+
+// highWaterResult returns a result of the container id with the memory usage,
+// started at startedAt or not inspected if empty, and without stats if usage
+// is 0.
+func highWaterResult(id, startedAt string, usage uint64) *containerResult {
+	result := &containerResult{container: types.Container{ID: id}, labels: []string{id}}
+	if startedAt != "" {
+		result.info = &types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{
+			State: &types.ContainerState{StartedAt: startedAt},
+		}}
+	}
+	if usage > 0 {
+		result.metrics = &containerMetrics{memoryUsageBytes: usage}
+	}
+	return result
+}
+
+func TestMemoryHighWater(t *testing.T) {
+	const started, restarted = "2024-01-01T00:00:00Z", "2024-01-02T00:00:00Z"
+	listed := map[string]bool{"a": true}
+	steps := []struct {
+		name   string
+		result *containerResult
+		listed map[string]bool
+		// want is the memoryMaxSeen after the step, 0 if the result has no
+		// stats.
+		want uint64
+	}{
+		{"first read", highWaterResult("a", started, 300), listed, 300},
+		{"lower usage", highWaterResult("a", started, 100), listed, 300},
+		{"failed stats", highWaterResult("a", started, 0), listed, 0},
+		{"kept after failed stats", highWaterResult("a", started, 200), listed, 300},
+		{"failed inspect", highWaterResult("a", "", 100), listed, 300},
+		{"restart", highWaterResult("a", restarted, 100), listed, 100},
+		{"skipped by the scrape timeout", nil, listed, 0},
+		{"kept after skipping", highWaterResult("a", restarted, 50), listed, 100},
+		{"removed", nil, map[string]bool{}, 0},
+		{"fresh after removal", highWaterResult("a", restarted, 50), listed, 50},
+	}
+
+	hw := newMemoryHighWater()
+	for _, step := range steps {
+		var results []*containerResult
+		if step.result != nil {
+			results = append(results, step.result)
+		}
+		hw.update(results, step.listed)
+		if step.result != nil && step.result.memoryMaxSeen != step.want {
+			t.Errorf("%s: memoryMaxSeen = %d, want %d", step.name, step.result.memoryMaxSeen, step.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"sync"
+	"time"
+)
+
+var (
+	collectImageDetails = flag.Bool("collector.image-details", false, "Export per image metrics, such as the number of layers, for the images of the collected containers.")
+)
+
+// imageDetails is what the exporter uses from an image inspect.
+type imageDetails struct {
+	created time.Time
+	layers  int
+}
+
+// imageCache caches image inspect results by ID. Image IDs are content
+// addressed, so cached details never go stale.
+type imageCache struct {
+	mu sync.Mutex
+	// images holds nil for images that no longer exist.
+	images map[string]*imageDetails
+	// inspecting holds the inspects in flight, so that containers of the
+	// same image wait for one inspect instead of starting their own.
+	inspecting map[string]*imageInspect
+}
+
+// imageInspect is an image inspect in flight. details and err are set before
+// done is closed.
+type imageInspect struct {
+	done    chan struct{}
+	details *imageDetails
+	err     error
+}
+
+// get returns the details of the image, inspecting it on a cache miss. It
+// returns nil for images that have been deleted while a container still uses
+// them. Errors are not cached, but are returned to every caller waiting for
+// the failed inspect.
+func (c *imageCache) get(ctx context.Context, cli *client.Client, limiter *rate.Limiter, imageID string) (*imageDetails, error) {
+	c.mu.Lock()
+	if details, cached := c.images[imageID]; cached {
+		c.mu.Unlock()
+		return details, nil
+	}
+	if inspect, ok := c.inspecting[imageID]; ok {
+		c.mu.Unlock()
+		select {
+		case <-inspect.done:
+			return inspect.details, inspect.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	inspect := &imageInspect{done: make(chan struct{})}
+	if c.inspecting == nil {
+		c.inspecting = map[string]*imageInspect{}
+	}
+	c.inspecting[imageID] = inspect
+	c.mu.Unlock()
+
+	inspect.details, inspect.err = inspectImage(ctx, cli, limiter, imageID)
+
+	c.mu.Lock()
+	delete(c.inspecting, imageID)
+	if inspect.err == nil {
+		c.set(imageID, inspect.details)
+	}
+	c.mu.Unlock()
+	close(inspect.done)
+	return inspect.details, inspect.err
+}
+
+// inspectImage inspects an image, returning nil details if it doesn't exist.
+func inspectImage(ctx context.Context, cli *client.Client, limiter *rate.Limiter, imageID string) (*imageDetails, error) {
+	if err := limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	image, _, err := cli.ImageInspectWithRaw(ctx, imageID)
+	if errdefs.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := time.Parse(time.RFC3339Nano, image.Created)
+	if err != nil {
+		return nil, err
+	}
+	details := &imageDetails{created: created}
+	if image.RootFS.Type == "layers" {
+		details.layers = len(image.RootFS.Layers)
+	}
+	return details, nil
+}
+
+// set caches the details of an image. The caller must hold c.mu.
+func (c *imageCache) set(imageID string, details *imageDetails) {
+	if c.images == nil {
+		c.images = map[string]*imageDetails{}
+	}
+	c.images[imageID] = details
+}
+
+// prune drops the images that aren't in seen.
+func (c *imageCache) prune(seen map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id := range c.images {
+		if !seen[id] {
+			delete(c.images, id)
+		}
+	}
+}
+
+// emitImageDetails sends the per image metrics, once for every image used by
+// the containers in results. The image label is the reference of the first
+// container using it.
+func emitImageDetails(ch chan<- prometheus.Metric, results []*containerResult) {
+	seen := map[string]bool{}
+	for _, result := range results {
+		id := result.container.ImageID
+		if result.image == nil || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ch <- prometheus.MustNewConstMetric(imageLayersDesc, prometheus.GaugeValue, float64(result.image.layers), id, result.container.Image)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"github.com/docker/docker/client"
+	"golang.org/x/time/rate"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestImageCacheInspectsOnce(t *testing.T) {
+	var inspects int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&inspects, 1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"Id": "sha256:1", "Created": "2024-01-01T00:00:00Z", "RootFS": {"Type": "layers", "Layers": ["a", "b"]}}`))
+	}))
+	defer srv.Close()
+	cli, err := client.NewClientWithOpts(client.WithHost(strings.Replace(srv.URL, "http://", "tcp://", 1)), client.WithVersion("1.43"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var c imageCache
+	limiter := rate.NewLimiter(rate.Inf, 0)
+	var wg sync.WaitGroup
+	details := make([]*imageDetails, 5)
+	errs := make([]error, len(details))
+	for i := range details {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			details[i], errs[i] = c.get(context.Background(), cli, limiter, "sha256:1")
+		}(i)
+	}
+	// Let every get find the inspect in flight before it completes.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&inspects); n != 1 {
+		t.Errorf("got %d image inspects, want 1", n)
+	}
+	for i := range details {
+		if errs[i] != nil || details[i] == nil || details[i].layers != 2 {
+			t.Errorf("get() = %+v, %v, want 2 layers", details[i], errs[i])
+		}
+	}
+}
+
+func TestImageCacheGetCanceledWhileWaiting(t *testing.T) {
+	c := imageCache{inspecting: map[string]*imageInspect{"sha256:1": {done: make(chan struct{})}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.get(ctx, nil, nil, "sha256:1"); err != context.Canceled {
+		t.Errorf("get() error = %v, want %v", err, context.Canceled)
+	}
+}
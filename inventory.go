@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"sync"
+)
+
+var (
+	enableDebug = flag.Bool("web.enable-debug", false, "Serve debugging endpoints, such as the container inventory on /containers.")
+)
+
+// containerInventory describes a container seen during the last collection.
+type containerInventory struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Image string `json:"image"`
+	State string `json:"state"`
+	// Labels are the metric labels applied to the container.
+	Labels map[string]string `json:"labels"`
+	// Exported is false for containers left out by -docker.top-n or whose
+	// stats could not be read.
+	Exported bool `json:"exported"`
+}
+
+// inventory keeps the containers seen during the last collection.
+type inventory struct {
+	mu         sync.RWMutex
+	containers []containerInventory
+}
+
+// update replaces the inventory with results, marking which of them had their
+// metrics exported.
+func (inv *inventory) update(results, exported []*containerResult) {
+	isExported := make(map[string]bool, len(exported))
+	for _, result := range exported {
+		isExported[result.container.ID] = result.metrics != nil
+	}
+
+	containers := make([]containerInventory, 0, len(results))
+	for _, result := range results {
+		labels := make(map[string]string, len(containerLabelNames))
+		for i, name := range containerLabelNames {
+			if i < len(result.labels) {
+				labels[name] = result.labels[i]
+			}
+		}
+		containers = append(containers, containerInventory{
+			ID:       result.container.ID,
+			Name:     containerName(result.container),
+			Image:    result.container.Image,
+			State:    result.container.State,
+			Labels:   labels,
+			Exported: isExported[result.container.ID],
+		})
+	}
+
+	inv.mu.Lock()
+	inv.containers = containers
+	inv.mu.Unlock()
+}
+
+// ServeHTTP serves the inventory as a JSON array on GET /containers.
+func (inv *inventory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Only GET requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	containers := inv.containers
+	if containers == nil {
+		containers = []containerInventory{}
+	}
+	json.NewEncoder(w).Encode(containers)
+}
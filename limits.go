@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	unlimitedAs = flag.String("metrics.unlimited-as", "skip", "How memory and pids limits of containers without a limit are exported: skip omits the metric, zero exports 0, raw exports the value Docker reports, e.g. the host memory.")
+)
+
+func validateUnlimitedAs() error {
+	switch *unlimitedAs {
+	case "skip", "zero", "raw":
+		return nil
+	}
+	return fmt.Errorf("invalid -metrics.unlimited-as %q, must be skip, zero or raw", *unlimitedAs)
+}
+
+// emitLimits sends the memory and pids limits of a container, and the memory
+// usage relative to its limit. Whether a limit is set comes from inspect, the
+// values from the stats, which also report a value for unlimited containers.
+func emitLimits(ch chan<- prometheus.Metric, info types.ContainerJSON, metrics *containerMetrics, labels []string) {
+	if info.HostConfig == nil {
+		return
+	}
+	memoryLimited := info.HostConfig.Memory > 0
+	pidsLimited := info.HostConfig.PidsLimit != nil && *info.HostConfig.PidsLimit > 0
+
+	emitLimit(ch, containerMemoryLimitDesc, memoryLimited, float64(metrics.memoryLimitBytes), labels)
+	emitLimit(ch, containerPidsLimitDesc, pidsLimited, float64(metrics.pidsLimit), labels)
+
+	if memoryLimited && metrics.memoryLimitBytes > 0 {
+		ratio := float64(metrics.memoryUsageBytes) / float64(metrics.memoryLimitBytes)
+		ch <- prometheus.MustNewConstMetric(memoryUsageRatioDesc, prometheus.GaugeValue, ratio, labels...)
+	}
+}
+
+func emitLimit(ch chan<- prometheus.Metric, desc *prometheus.Desc, limited bool, value float64, labels []string) {
+	if !limited {
+		switch *unlimitedAs {
+		case "skip":
+			return
+		case "zero":
+			value = 0
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+}
+
+// cpuLimit returns the number of CPUs a container is limited to, or 0 when it
+// has no CPU limit.
+func cpuLimit(hostConfig *container.HostConfig) float64 {
+	if hostConfig.NanoCPUs > 0 {
+		return float64(hostConfig.NanoCPUs) / 1e9
+	}
+	if hostConfig.CPUQuota > 0 {
+		period := hostConfig.CPUPeriod
+		if period == 0 {
+			// The kernel's default CFS period, 100ms.
+			period = 100000
+		}
+		return float64(hostConfig.CPUQuota) / float64(period)
+	}
+	return 0
+}
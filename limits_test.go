@@ -0,0 +1,84 @@
+package main
+
+import (
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"testing"
+)
+
+// limitValues runs emitLimits and returns the memory and pids limits it sent,
+// nil for the ones it didn't.
+func limitValues(t *testing.T, info types.ContainerJSON, metrics *containerMetrics) (memory, pids *float64) {
+	ch := make(chan prometheus.Metric, 10)
+	emitLimits(ch, info, metrics, make([]string, len(containerLabelNames)))
+	close(ch)
+
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		value := pb.GetGauge().GetValue()
+		switch m.Desc() {
+		case containerMemoryLimitDesc:
+			memory = &value
+		case containerPidsLimitDesc:
+			pids = &value
+		}
+	}
+	return memory, pids
+}
+
+func TestUnlimitedAs(t *testing.T) {
+	initDescs()
+	const hostMemory, hostPids = 8e9, 1e6
+	limit := int64(100)
+	limited := types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{
+		HostConfig: &container.HostConfig{Resources: container.Resources{Memory: 1e9, PidsLimit: &limit}},
+	}}
+	unlimited := types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{
+		HostConfig: &container.HostConfig{},
+	}}
+
+	skipped := -1.0
+	tests := []struct {
+		mode string
+		info types.ContainerJSON
+		// The stats report the host's values for unlimited containers.
+		metrics    *containerMetrics
+		wantMemory float64
+		wantPids   float64
+	}{
+		{"skip", limited, &containerMetrics{memoryLimitBytes: 1e9, pidsLimit: 100}, 1e9, 100},
+		{"skip", unlimited, &containerMetrics{memoryLimitBytes: hostMemory, pidsLimit: hostPids}, skipped, skipped},
+		{"zero", limited, &containerMetrics{memoryLimitBytes: 1e9, pidsLimit: 100}, 1e9, 100},
+		{"zero", unlimited, &containerMetrics{memoryLimitBytes: hostMemory, pidsLimit: hostPids}, 0, 0},
+		{"raw", limited, &containerMetrics{memoryLimitBytes: 1e9, pidsLimit: 100}, 1e9, 100},
+		{"raw", unlimited, &containerMetrics{memoryLimitBytes: hostMemory, pidsLimit: hostPids}, hostMemory, hostPids},
+	}
+	for _, tt := range tests {
+		name := tt.mode + "/limited"
+		if tt.info.HostConfig.Memory == 0 {
+			name = tt.mode + "/unlimited"
+		}
+		t.Run(name, func(t *testing.T) {
+			setTestFlag(t, "metrics.unlimited-as", tt.mode)
+
+			memory, pids := limitValues(t, tt.info, tt.metrics)
+			check := func(metric string, got *float64, want float64) {
+				switch {
+				case want == skipped && got != nil:
+					t.Errorf("%s = %v, want it skipped", metric, *got)
+				case want != skipped && got == nil:
+					t.Errorf("%s skipped, want %v", metric, want)
+				case want != skipped && *got != want:
+					t.Errorf("%s = %v, want %v", metric, *got, want)
+				}
+			}
+			check("container_memory_limit_bytes", memory, tt.wantMemory)
+			check("container_pids_limit", pids, tt.wantPids)
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+var (
+	logLevel = flag.String("log.level", "info", "Minimum level of the log messages, either info or debug.")
+)
+
+// debugEnabled mirrors -log.level so it can be read without holding any lock.
+var debugEnabled atomic.Bool
+
+// applyLogLevel validates -log.level and applies it.
+func applyLogLevel() error {
+	switch *logLevel {
+	case "debug":
+		debugEnabled.Store(true)
+	case "info":
+		debugEnabled.Store(false)
+	default:
+		return fmt.Errorf("invalid -log.level %q, must be info or debug", *logLevel)
+	}
+	return nil
+}
+
+// logDebug logs like log.Println, but only with -log.level=debug.
+func logDebug(v ...interface{}) {
+	if debugEnabled.Load() {
+		log.Println(v...)
+	}
+}
@@ -3,102 +3,1804 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-units"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/model"
+	"golang.org/x/time/rate"
+	"hash/fnv"
+	"io"
 	"log"
+	"math"
 	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode/utf8"
 )
 
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
 const (
 	namespace = "docker_exporter"
+
+	composeProjectLabel         = "com.docker.compose.project"
+	composeServiceLabel         = "com.docker.compose.service"
+	composeContainerNumberLabel = "com.docker.compose.container-number"
+
+	// zeroDeltaRereadPause is how long to wait before re-reading a stats
+	// sample that had no CPU delta.
+	zeroDeltaRereadPause = 100 * time.Millisecond
 )
 
+// constantLabels are attached to every exported metric.
+var constantLabels = labelsFlag{}
+
+func init() {
+	flag.Var(&listenAddresses, "web.listen-address", "Address to listen on for HTTP requests. Can be repeated to listen on several addresses. Defaults to "+defaultListenAddress+".")
+	flag.Var(&dockerHosts, "docker.host", "Docker daemon endpoint, e.g. unix:///var/run/docker.sock or tcp://dind:2375. Defaults to $DOCKER_HOST, then the local socket. Can be repeated to collect from several daemons, labeled with docker_host.")
+	flag.Var(&includeLabels, "docker.include-label", "Only collect containers with this label, given as key or key=value. Can be repeated, containers must have all of them.")
+	flag.Var(&excludeLabels, "docker.exclude-label", "Don't collect containers with this label, given as key or key=value. Can be repeated.")
+	flag.Var(constantLabels, "metrics.constant-labels", "Comma separated key=value pairs added as labels to every metric, e.g. datacenter=eu1,env=prod.")
+}
+
 var (
-	cpuUsageDesc = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "cpu_usage_percent"),
-		"Container CPU Usage Percentage",
-		[]string{"container_id"}, nil,
-	)
+	collectGo      = flag.Bool("collector.go", true, "Export Go runtime metrics of the exporter itself.")
+	collectProcess = flag.Bool("collector.process", true, "Export process metrics of the exporter itself.")
 
-	memoryUsageDesc = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "memory_usage_bytes"),
-		"Container Memory Usage in bytes",
-		[]string{"container_id"}, nil,
-	)
+	noIDLabel             = flag.Bool("metrics.no-id-label", false, "Label container metrics by name, image and Compose service instead of container ID, so that recreated containers continue the same series.")
+	labelAllowlist        = flag.String("docker.label-allowlist", "", "Comma separated Docker label names whose values are added as label_<name> labels to container metrics, e.g. com.docker.compose.project.")
+	exposeEnvVars         = flag.String("docker.expose-env", "", "Comma separated environment variable names whose values are added as env_<name> labels to container metrics. Never list variables holding secrets.")
+	refreshInterval       = flag.Duration("docker.refresh-interval", 0, "Collect in the background at this interval and serve the last snapshot on /metrics. 0 collects on every scrape.")
+	labelTemplate         = flag.String("docker.label-template", "", "Go text/template evaluated against each container's metadata (.ID, .Name, .Image, .Labels) to produce a \"service\" label.")
+	collectContainerSize  = flag.Bool("collector.container-size", false, "Export the size of the containers' writable layer and root filesystem. Expensive for the Docker daemon.")
+	collectCommandInfo    = flag.Bool("collector.command-info", false, "Export docker_exporter_container_command_info with the container's command as a label.")
+	collectCapabilityInfo = flag.Bool("collector.capability-info", false, "Export container_capability_info with one series per capability added to a container.")
+	commandInfoMaxLen     = flag.Int("collector.command-info.max-length", 128, "Maximum length of the command label, longer commands are truncated.")
+	rereadOnZeroDelta     = flag.Bool("docker.reread-on-zero-delta", false, "Read the stats of a running container a second time when the first sample has no CPU delta.")
+	memoryExcludeKernel   = flag.Bool("metrics.memory-exclude-kernel", false, "Exclude kernel memory (slab, kernel stack, socket buffers) from the reported memory usage on cgroup v2.")
+	cpuPrecision          = flag.Int("metrics.cpu-precision", -1, "Round cpu_usage_percent to this many decimal places. Negative values disable rounding.")
+	cpuSamples            = flag.Int("docker.cpu-samples", 1, "Average the CPU usage over this many consecutive samples of the stats stream, about one per second. 1 uses the single sample of a one-shot stats request.")
+	stableOrder           = flag.Bool("metrics.stable-order", false, "Collect and emit containers sorted by ID, so that the collector's output order is deterministic.")
+	topN                  = flag.Int("docker.top-n", 0, "Only export metrics for the N containers with the highest usage, see -docker.top-by. 0 exports all containers.")
+	topBy                 = flag.String("docker.top-by", "cpu", "Resource used to rank containers for -docker.top-n, either cpu or memory.")
+	perContainerTimeout   = flag.Duration("docker.per-container-timeout", 3*time.Second, "Timeout for fetching the stats of a single container. Containers that time out are skipped for that scrape.")
+	maxStatsBytes         = flag.Int64("docker.max-stats-bytes", 4<<20, "Maximum size in bytes of a stats response. Containers whose response is larger are skipped for that scrape.")
+	imageRegexp           = flag.String("docker.image-regexp", "", "Only collect containers whose image matches this regular expression, anchored at both ends. Empty collects all containers.")
+	scrapeTimeout         = flag.Duration("docker.scrape-timeout", 0, "Timeout for collecting all containers of a daemon. Containers not collected by then are left out of that scrape. 0 disables it.")
+	includeExited         = flag.Bool("docker.include-exited", true, "Also collect exited containers, so that crashed containers keep their state, exit code and restart metrics. Their stats aren't read.")
+	workers               = flag.Int("collector.workers", 8, "Number of containers to inspect and read the stats of concurrently.")
+	minAge                = flag.Duration("docker.min-age", 0, "Only collect containers created at least this long ago, to leave out short-lived ones. 0 collects all containers.")
 )
 
+// labelsFlag is a flag.Value for a comma separated list of key=value labels.
+type labelsFlag map[string]string
+
+func (f labelsFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for k, v := range f {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f labelsFlag) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		if pair == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("label %q is not in key=value form", pair)
+		}
+		if !model.LabelName(k).IsValid() {
+			return fmt.Errorf("invalid label name %q", k)
+		}
+		f[k] = v
+	}
+	return nil
+}
+
+// checkConstantLabels returns an error if a -metrics.constant-labels key is
+// also a label of one of the exported metrics, which would make registering
+// them fail. The collectors are only described to a scratch registry.
+func checkConstantLabels(daemons []daemon) error {
+	if len(constantLabels) == 0 {
+		return nil
+	}
+	reg := prometheus.WrapRegistererWith(prometheus.Labels(constantLabels), prometheus.NewRegistry())
+
+	// The handler metrics of promhttp.InstrumentMetricHandler have a code
+	// label.
+	checked := []prometheus.Collector{
+		newConfigInfo(),
+		prometheus.NewCounterVec(prometheus.CounterOpts{Name: "promhttp_metric_handler_requests_total"}, []string{"code"}),
+	}
+	if *collectGo {
+		checked = append(checked, collectors.NewGoCollector())
+	}
+	for _, c := range checked {
+		if err := reg.Register(c); err != nil {
+			return fmt.Errorf("invalid -metrics.constant-labels: %w", err)
+		}
+	}
+	for _, d := range daemons {
+		if err := d.checkLabels(reg); err != nil {
+			return fmt.Errorf("invalid -metrics.constant-labels: %w", err)
+		}
+	}
+	return nil
+}
+
+// stringsFlag is a flag.Value collecting every value of a repeated flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// containerTemplateData is what -docker.label-template is executed against.
+type containerTemplateData struct {
+	ID     string
+	Name   string
+	Image  string
+	Labels map[string]string
+}
+
+// containerMetrics holds the values derived from a single stats sample.
+type containerMetrics struct {
+	cpuUsagePercent      float64
+	cpuKernelModeSeconds float64
+	cpuUserModeSeconds   float64
+	memoryUsageBytes     uint64
+	// cpuUsageSeconds and memoryUsageRawBytes are the raw totals reported by
+	// Docker, only exported in -metrics.compat=cadvisor mode.
+	cpuUsageSeconds     float64
+	memoryUsageRawBytes uint64
+	// cpuUsageUnrounded is cpuUsagePercent before -metrics.cpu-precision.
+	cpuUsageUnrounded float64
+
+	// The page cache, anonymous memory and swap. The has fields are false
+	// when the stats don't report them, e.g. on Windows or, for swap, on
+	// cgroup v2.
+	hasMemoryCache   bool
+	memoryCacheBytes uint64
+	hasMemoryRSS     bool
+	memoryRSSBytes   uint64
+	hasMemorySwap    bool
+	memorySwapBytes  uint64
+
+	// The limits as reported in the stats, which is the host memory for
+	// containers without a memory limit.
+	memoryLimitBytes uint64
+	pidsLimit        uint64
+
+	// CPU quota enforcement, all zero for containers without a CPU limit.
+	cpuPeriods          uint64
+	cpuThrottledPeriods uint64
+	cpuThrottledSeconds float64
+	// cpuThrottledInSample is true when the throttled periods grew between
+	// the two readings of the stats sample.
+	cpuThrottledInSample bool
+
+	// hasBlkioOps is false when the daemon reported no serviced I/O entries,
+	// which happens on some cgroup v2 hosts.
+	hasBlkioOps   bool
+	blkioReadOps  uint64
+	blkioWriteOps uint64
+	// blkioDevices is only set with -collector.blkio-per-device.
+	blkioDevices []blkioDevice
+	// hasBlkioBytes is false when the daemon reported no I/O bytes entries.
+	hasBlkioBytes   bool
+	blkioReadBytes  uint64
+	blkioWriteBytes uint64
+
+	// networks holds the counters of each network interface, keyed by
+	// interface name. It is empty for containers on the host network.
+	networks map[string]types.NetworkStats
+}
+
+// cachedLabels are the label values of a container, valid as long as the
+// container has the same token.
+type cachedLabels struct {
+	token  string
+	values []string
+}
+
+// errEmptyStats is returned for stats responses that don't contain a sample.
+var errEmptyStats = errors.New("empty stats response")
+
+// errStatsTooLarge is returned for stats responses over -docker.max-stats-bytes.
+var errStatsTooLarge = errors.New("stats response exceeds -docker.max-stats-bytes")
+
+// containerResult is everything collected for one container during a scrape.
+type containerResult struct {
+	container types.Container
+	labels    []string
+	info      *types.ContainerJSON // nil if inspect failed
+	metrics   *containerMetrics    // nil if reading the stats failed
+
+	// memoryMaxSeen is the memory high-water mark of the container series.
+	memoryMaxSeen uint64
+
+	// pressure is keyed by PSI resource, only set with -collector.psi.
+	pressure map[string][]pressureStats
+
+	// image is nil when the image couldn't be inspected.
+	image *imageDetails
+
+	// sampledAt is the time of the streamed stats sample that metrics is
+	// from, only set with -collector.stats-streams.
+	sampledAt time.Time
+}
+
 type dockerCollector struct {
 	dockerClient *client.Client
+	// limiter must be waited on before every Docker API call.
+	limiter *rate.Limiter
+
+	// mu guards the settings below, which can change on a config reload.
+	// Collect holds it for reading for the whole collection.
+	mu            sync.RWMutex
+	labelTemplate *template.Template
+	allowedLabels []string
+	exposeEnv     []string
+	imageRegexp   *regexp.Regexp
+	filter        *containerFilter
+
+	templateErrOnce sync.Once
+
+	statsTimeouts prometheus.Counter
+	// scrapeErrors counts failed Docker API calls by scrapeErrorTypes, and
+	// containerFailures the failed inspect and stats calls per container.
+	scrapeErrors      *prometheus.CounterVec
+	containerFailures *prometheus.CounterVec
+	// failed holds the names that containerFailures has a series for.
+	failedMu sync.Mutex
+	failed   map[string]bool
+
+	memoryHighWater *memoryHighWater
+	inventory       inventory
+	cgroupInfo      cgroupInfo
+	images          imageCache
+
+	// windowed is only set with -collector.windowed-stats.
+	windowed *windowedStats
+	// streams is only set with -collector.stats-streams.
+	streams *statsStreams
+	// diskUsage is only set with -collector.disk-usage.
+	diskUsage *diskUsageCollector
+
+	// labelCache holds the label values of every listed container. It is
+	// only used by collect, and reset by applySettings. labelMu guards it
+	// while the containers are collected concurrently.
+	labelMu    sync.Mutex
+	labelCache map[string]cachedLabels
+
+	// collecting is held while a collection runs. Scrapes that overlap with
+	// it are answered with lastMetrics, the output of the previous one.
+	collecting     sync.Mutex
+	lastMu         sync.Mutex
+	lastMetrics    []prometheus.Metric
+	scrapeOverlaps prometheus.Counter
+}
+
+func newDockerCollector(cli *client.Client) *dockerCollector {
+	return &dockerCollector{
+		dockerClient: cli,
+		limiter:      newRateLimiter(),
+
+		memoryHighWater: newMemoryHighWater(),
+		statsTimeouts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "container_stats_timeouts_total",
+			Help:      "Number of container stats requests that exceeded -docker.per-container-timeout",
+		}),
+		scrapeErrors: newScrapeErrors(),
+		containerFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "container_collection_failures_total",
+			Help:      "Number of failed inspect and stats requests for a container, by container name; dropped once the container is gone",
+		}, []string{"name"}),
+		failed: map[string]bool{},
+		scrapeOverlaps: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "scrape_overlaps_total",
+			Help:      "Number of scrapes that arrived while a collection was still running and got the previous collection's metrics",
+		}),
+	}
 }
 
-func newDockerCollector() (*dockerCollector, error) {
-	cli, err := client.NewClientWithOpts(client.WithVersion("1.41")) // Use the appropriate Docker API version
+// applySettings derives the collector's settings from the current flag values
+// and rebuilds the container descriptors to match. Once the collector is
+// registered the caller must hold dc.mu for writing.
+func (dc *dockerCollector) applySettings() error {
+	if err := applyLogLevel(); err != nil {
+		return err
+	}
+	if *topBy != "cpu" && *topBy != "memory" {
+		return fmt.Errorf("invalid -docker.top-by %q, must be cpu or memory", *topBy)
+	}
+	if err := validateUnlimitedAs(); err != nil {
+		return err
+	}
+	if *workers < 1 {
+		return fmt.Errorf("invalid -collector.workers %d, must be at least 1", *workers)
+	}
+	if *cpuSamples > 1 && *perContainerTimeout <= time.Duration(*cpuSamples+1)*time.Second {
+		log.Printf("WARNING: -docker.cpu-samples=%d takes about %ds per container, more than -docker.per-container-timeout=%s allows", *cpuSamples, *cpuSamples+1, *perContainerTimeout)
+	}
+
+	imageRe, err := compileAnchored(*imageRegexp)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("parsing -docker.image-regexp: %w", err)
+	}
+	filter, err := newContainerFilter()
+	if err != nil {
+		return err
 	}
 
-	return &dockerCollector{
-		dockerClient: cli,
-	}, nil
+	var tmpl *template.Template
+	if *labelTemplate != "" {
+		var err error
+		tmpl, err = template.New("label").Option("missingkey=zero").Parse(*labelTemplate)
+		if err != nil {
+			return fmt.Errorf("parsing label template: %w", err)
+		}
+	}
+
+	if err := validateCompat(); err != nil {
+		return err
+	}
+
+	switch {
+	case *metricsCompat == "cadvisor":
+		containerLabelNames = append([]string{}, cadvisorLabelNames...)
+	case *noIDLabel:
+		containerLabelNames = []string{"name", "image_repository", "image_tag", "compose_service", "replica"}
+	default:
+		containerLabelNames = []string{"container_id", "name", "image"}
+	}
+	if tmpl != nil {
+		containerLabelNames = append(containerLabelNames, "service")
+	}
+
+	var allowedLabels []string
+	for _, name := range strings.Split(*labelAllowlist, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		label := dockerLabelName(name)
+		for _, existing := range containerLabelNames {
+			if existing == label {
+				return fmt.Errorf("Docker label %s maps to duplicate label %s", name, label)
+			}
+		}
+		allowedLabels = append(allowedLabels, name)
+		containerLabelNames = append(containerLabelNames, label)
+	}
+
+	var exposeEnv []string
+	for _, name := range strings.Split(*exposeEnvVars, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		label := envLabelName(name)
+		for _, existing := range containerLabelNames {
+			if existing == label {
+				return fmt.Errorf("environment variable %s maps to duplicate label %s", name, label)
+			}
+		}
+		exposeEnv = append(exposeEnv, name)
+		containerLabelNames = append(containerLabelNames, label)
+	}
+
+	dc.allowedLabels = allowedLabels
+	dc.exposeEnv = exposeEnv
+	dc.labelTemplate = tmpl
+	dc.imageRegexp = imageRe
+	dc.filter = filter
+	dc.templateErrOnce = sync.Once{}
+	dc.labelCache = map[string]cachedLabels{}
+	initContainerDescs()
+	if dc.streams != nil {
+		dc.streams.reselect()
+	}
+
+	// The previous metrics may have been built from the old descriptors.
+	dc.lastMu.Lock()
+	dc.lastMetrics = nil
+	dc.lastMu.Unlock()
+
+	return nil
 }
 
 func (dc *dockerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- upDesc
+	ch <- scrapeSuccessDesc
+	ch <- dockerUpDesc
+	ch <- containersTotalDesc
+	ch <- cgroupInfoDesc
 	ch <- cpuUsageDesc
+	ch <- cpuUsageLimitDesc
 	ch <- memoryUsageDesc
+	ch <- memoryMaxSeenDesc
+	ch <- memoryCacheDesc
+	ch <- memoryRSSDesc
+	ch <- memorySwapDesc
+	ch <- memoryUsageRatioDesc
+	ch <- containerMemoryLimitDesc
+	ch <- containerPidsLimitDesc
+	ch <- cpuKernelModeDesc
+	ch <- cpuUserModeDesc
+	ch <- cpuPeriodsDesc
+	ch <- cpuThrottledPeriodsDesc
+	ch <- cpuThrottledTimeDesc
+	ch <- containersThrottledDesc
+	ch <- containersScrapedDesc
+	ch <- composeServiceContainersDesc
+	ch <- composeServiceCPUUsageDesc
+	ch <- composeServiceMemoryUsageDesc
+	ch <- composeServiceNetworkRxBytesDesc
+	ch <- composeServiceNetworkTxBytesDesc
+	ch <- composeServiceNetworkRxPacketsDesc
+	ch <- composeServiceNetworkTxPacketsDesc
+	ch <- composeServiceNetworkRxDroppedDesc
+	ch <- composeServiceNetworkTxDroppedDesc
+	ch <- scrapeDurationPerContainerDesc
+	ch <- scrapeDurationDesc
+	ch <- totalCPUUsageDesc
+	ch <- totalMemoryUsageDesc
+	ch <- averageCPUUsageDesc
+	ch <- averageMemoryUsageDesc
+	ch <- blkioReadOpsDesc
+	ch <- blkioWriteOpsDesc
+	ch <- blkioReadBytesDesc
+	ch <- blkioWriteBytesDesc
+	ch <- blkioDeviceReadBytesDesc
+	ch <- blkioDeviceWriteBytesDesc
+	ch <- networkRxBytesDesc
+	ch <- networkTxBytesDesc
+	ch <- networkRxPacketsDesc
+	ch <- networkTxPacketsDesc
+	ch <- networkRxDroppedDesc
+	ch <- networkTxDroppedDesc
+	ch <- containerStatsSampleTimestampDesc
+	ch <- containerStateDesc
+	ch <- containerCreatedDesc
+	ch <- containerStartTimeDesc
+	ch <- containerExitCodeDesc
+	ch <- containerHealthStatusDesc
+	ch <- containerImageAgeDesc
+	ch <- imageLayersDesc
+	ch <- containerHealthScoreDesc
+	ch <- containerRestartingDesc
+	ch <- containerRestartCountDesc
+	ch <- containerCommandInfoDesc
+	ch <- containerStartLatencyDesc
+	ch <- containerSizeRwDesc
+	ch <- containerSizeRootFsDesc
+	ch <- containerConfigHashDesc
+	ch <- containerLogDriverDesc
+	ch <- containerLogMaxSizeDesc
+	ch <- containerLogMaxFilesDesc
+	ch <- containerPrivilegedDesc
+	ch <- containerCapabilitiesAddedDesc
+	ch <- containerCapabilityInfoDesc
+	ch <- containerGPUCountDesc
+	ch <- containerGPUInfoDesc
+	if cpuUsageSecondsDesc != nil {
+		ch <- cpuUsageSecondsDesc
+		ch <- memoryUsageRawDesc
+	}
+	for _, stat := range windowStats {
+		ch <- windowCPUUsageDescs[stat]
+		ch <- windowMemoryUsageDescs[stat]
+	}
+	for _, resource := range psiResources {
+		ch <- containerPressureRatioDescs[resource]
+		ch <- containerPressureStalledDescs[resource]
+	}
+	dc.statsTimeouts.Describe(ch)
+	dc.scrapeErrors.Describe(ch)
+	dc.containerFailures.Describe(ch)
+	dc.scrapeOverlaps.Describe(ch)
 }
 
+// Collect runs a collection, unless one is already running. Queueing behind
+// it would only pile up more Docker API calls, so overlapping scrapes are
+// served the metrics of the last completed collection instead.
 func (dc *dockerCollector) Collect(ch chan<- prometheus.Metric) {
-	containers, err := dc.dockerClient.ContainerList(context.Background(), types.ContainerListOptions{})
+	defer dc.scrapeOverlaps.Collect(ch)
+	defer dc.containerFailures.Collect(ch)
+	defer dc.scrapeErrors.Collect(ch)
+	defer dc.statsTimeouts.Collect(ch)
+
+	if !dc.collecting.TryLock() {
+		dc.scrapeOverlaps.Inc()
+		logDebug("Collection still running, serving the previous metrics")
+		dc.lastMu.Lock()
+		defer dc.lastMu.Unlock()
+		for _, m := range dc.lastMetrics {
+			ch <- m
+		}
+		return
+	}
+	defer dc.collecting.Unlock()
+
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		dc.collect(metrics)
+		close(metrics)
+	}()
+
+	var collected []prometheus.Metric
+	for m := range metrics {
+		collected = append(collected, m)
+		ch <- m
+	}
+
+	dc.lastMu.Lock()
+	dc.lastMetrics = collected
+	dc.lastMu.Unlock()
+}
+
+// selectContainer reports whether a listed container is collected, given the
+// -docker.image-regexp and -docker.filter of the collector. Created comes
+// with the list, so young containers are skipped without an inspect.
+func selectContainer(container types.Container, imageRe *regexp.Regexp, filter *containerFilter) bool {
+	if imageRe != nil && !imageRe.MatchString(container.Image) {
+		return false
+	}
+	if !filter.matches(container) {
+		return false
+	}
+	return *minAge <= 0 || time.Since(time.Unix(container.Created, 0)) >= *minAge
+}
+
+func (dc *dockerCollector) collect(ch chan<- prometheus.Metric) {
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+
+	ctx := context.Background()
+	if *scrapeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *scrapeTimeout)
+		defer cancel()
+	}
+	start := time.Now()
+	defer func() {
+		ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, time.Since(start).Seconds())
+	}()
+	// up is always 1, so that a scrape of a host without containers can be
+	// told apart from an exporter that doesn't work.
+	ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 1)
+
+	containers, err := dc.listContainers(ctx)
 	if err != nil {
 		log.Println("Failed to list containers:", err)
+		dc.scrapeErrors.WithLabelValues("list").Inc()
+		ch <- prometheus.MustNewConstMetric(dockerUpDesc, prometheus.GaugeValue, 0, dockerUpLabelValues(dc.dockerClient)...)
+		ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, 0)
 		return
 	}
+	ch <- prometheus.MustNewConstMetric(dockerUpDesc, prometheus.GaugeValue, 1, dockerUpLabelValues(dc.dockerClient)...)
+	ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, 1)
+	dc.cgroupInfo.collect(ctx, ch, dc.dockerClient, dc.limiter)
+	if dc.streams != nil {
+		dc.streams.sync(containers)
+	}
 
+	selected := make([]types.Container, 0, len(containers))
+	images := map[string]bool{}
+	listed := make(map[string]bool, len(containers))
+	names := make(map[string]bool, len(containers))
 	for _, container := range containers {
-		cpuUsagePercent, memoryUsageBytes, err := dc.getContainerMetrics(container.ID)
-		if err != nil {
+		listed[container.ID] = true
+		names[containerName(container)] = true
+		if !selectContainer(container, dc.imageRegexp, dc.filter) {
+			continue
+		}
+		images[container.ImageID] = true
+		selected = append(selected, container)
+	}
+
+	results := dc.collectContainers(ctx, selected)
+	// Sorted before anything per container is emitted, including the image
+	// details.
+	if *stableOrder {
+		sort.Slice(results, func(i, j int) bool {
+			return results[i].container.ID < results[j].container.ID
+		})
+	}
+	scraped := 0
+	for _, result := range results {
+		if result.metrics != nil {
+			scraped++
+		}
+	}
+	dc.images.prune(images)
+	for id := range dc.labelCache {
+		if !listed[id] {
+			delete(dc.labelCache, id)
+		}
+	}
+	dc.pruneContainerFailures(names)
+	if *collectImageDetails {
+		emitImageDetails(ch, results)
+	}
+
+	ch <- prometheus.MustNewConstMetric(containersTotalDesc, prometheus.GaugeValue, float64(len(results)))
+
+	ch <- prometheus.MustNewConstMetric(containersScrapedDesc, prometheus.GaugeValue, float64(scraped))
+	if scraped > 0 {
+		perContainer := time.Since(start).Seconds() / float64(scraped)
+		ch <- prometheus.MustNewConstMetric(scrapeDurationPerContainerDesc, prometheus.GaugeValue, perContainer)
+	}
+
+	// The summary, throttled count and high-water marks cover every
+	// container, including the ones -docker.top-n leaves out.
+	emitSummary(ch, results)
+	throttled := 0
+	for _, result := range results {
+		if result.metrics != nil && result.metrics.cpuThrottledInSample {
+			throttled++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(containersThrottledDesc, prometheus.GaugeValue, float64(throttled))
+
+	dc.memoryHighWater.update(results, listed)
+
+	exported := results
+	if *topN > 0 {
+		exported = topContainers(results, *topN, *topBy)
+	}
+	if *enableDebug {
+		dc.inventory.update(results, exported)
+	}
+
+	perContainer := exported
+	if *aggregateCompose {
+		// Services sum over all containers, -docker.top-n only limits the
+		// per container metrics.
+		emitComposeServices(ch, results)
+		perContainer = standaloneContainers(exported)
+	}
+	for _, result := range perContainer {
+		dc.emitContainer(ch, result)
+	}
+}
+
+// collectContainers collects containers on -collector.workers goroutines, so
+// that the scrape duration doesn't grow with the number of containers. The
+// results are in the order of containers. Containers that weren't started on
+// when ctx ends are left out.
+func (dc *dockerCollector) collectContainers(ctx context.Context, containers []types.Container) []*containerResult {
+	results := make([]*containerResult, len(containers))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < *workers && w < len(containers); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = dc.collectContainer(ctx, containers[i])
+			}
+		}()
+	}
+	skipped := 0
+dispatch:
+	for i := range containers {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			skipped = len(containers) - i
+			break dispatch
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	if skipped == 0 {
+		return results
+	}
+	log.Println("Scrape timeout reached, skipped", skipped, "of", len(containers), "containers")
+	dc.scrapeErrors.WithLabelValues("scrape_timeout").Add(float64(skipped))
+	collected := make([]*containerResult, 0, len(containers)-skipped)
+	for _, result := range results {
+		if result != nil {
+			collected = append(collected, result)
+		}
+	}
+	return collected
+}
+
+// collectContainer inspects a container and reads its stats. Failures are
+// logged and leave the corresponding field of the result nil.
+func (dc *dockerCollector) collectContainer(ctx context.Context, container types.Container) *containerResult {
+	result := &containerResult{
+		container: container,
+	}
+
+	info, err := dc.inspectContainer(ctx, container.ID)
+	if err != nil {
+		log.Println("Failed to inspect container", container.ID, ":", err)
+		dc.countFailure("inspect", container)
+	} else {
+		result.info = &info
+	}
+	result.labels = dc.cachedLabelValues(container, result.info)
+
+	image, err := dc.images.get(ctx, dc.dockerClient, dc.limiter, container.ImageID)
+	if err != nil {
+		log.Println("Failed to inspect image", container.ImageID, "of container", container.ID, ":", err)
+		dc.scrapeErrors.WithLabelValues("image_inspect").Inc()
+	}
+	result.image = image
+
+	if *collectPSI && result.info != nil && result.info.State != nil && result.info.State.Running {
+		result.pressure = readContainerPressure(container.ID, result.info.State.Pid)
+	}
+
+	// Created containers have never run and exited, removing or dead ones
+	// no longer do, so there are no stats to read.
+	switch container.State {
+	case "created", "exited", "removing", "dead":
+		return result
+	}
+
+	if dc.streams != nil {
+		if metrics, at, ok := dc.streams.latest(container.ID); ok {
+			result.metrics, result.sampledAt = metrics, at
+			return result
+		}
+	}
+
+	statsCtx, cancel := context.WithTimeout(ctx, *perContainerTimeout)
+	defer cancel()
+	metrics, err := dc.getContainerMetrics(statsCtx, container.ID)
+	if err == nil && *rereadOnZeroDelta && metrics.cpuUsageUnrounded == 0 && container.State == "running" {
+		metrics = dc.rereadContainerMetrics(statsCtx, container.ID, metrics)
+	}
+	if errors.Is(err, errEmptyStats) {
+		logDebug("Skipping container", container.ID, "with empty stats")
+		return result
+	}
+	// The daemon answers 409 Conflict for containers in the middle of a
+	// restart. That is a transient state, which container_restarting already
+	// reports from the inspect, not a failure.
+	if errdefs.IsConflict(err) {
+		logDebug("Skipping restarting container", container.ID, ":", err)
+		return result
+	}
+	if err != nil {
+		// statsCtx also ends with the scrape, which is not the container's
+		// fault.
+		if ctx.Err() == context.DeadlineExceeded {
+			dc.scrapeErrors.WithLabelValues("scrape_timeout").Inc()
+			log.Println("Scrape timeout reached getting metrics for container", container.ID)
+		} else if statsCtx.Err() == context.DeadlineExceeded {
+			dc.statsTimeouts.Inc()
+			dc.countFailure("stats_timeout", container)
+			log.Println("Timed out getting metrics for container", container.ID)
+		} else {
+			dc.countFailure("stats", container)
 			log.Println("Failed to get metrics for container", container.ID, ":", err)
+		}
+		return result
+	}
+	result.metrics = metrics
+
+	return result
+}
+
+// scrapeErrorTypes are the values of the type label of
+// docker_exporter_scrape_errors_total.
+var scrapeErrorTypes = []string{"list", "inspect", "image_inspect", "stats", "stats_timeout", "scrape_timeout"}
+
+// newScrapeErrors creates docker_exporter_scrape_errors_total with every type
+// at 0, so that rate() works from the first error on.
+func newScrapeErrors() *prometheus.CounterVec {
+	errs := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scrape_errors_total",
+		Help:      "Number of failed Docker API calls during collections, by type; scrape_timeout counts the containers skipped or cut short by -docker.scrape-timeout",
+	}, []string{"type"})
+	for _, typ := range scrapeErrorTypes {
+		errs.WithLabelValues(typ)
+	}
+	return errs
+}
+
+// countFailure counts a failed call of the given scrapeErrorTypes type for a
+// container.
+func (dc *dockerCollector) countFailure(typ string, container types.Container) {
+	dc.scrapeErrors.WithLabelValues(typ).Inc()
+
+	name := containerName(container)
+	dc.failedMu.Lock()
+	defer dc.failedMu.Unlock()
+	dc.failed[name] = true
+	dc.containerFailures.WithLabelValues(name).Inc()
+}
+
+// pruneContainerFailures drops the failure counters of the containers whose
+// name isn't in names anymore, so that removed containers don't leave series
+// behind.
+func (dc *dockerCollector) pruneContainerFailures(names map[string]bool) {
+	dc.failedMu.Lock()
+	defer dc.failedMu.Unlock()
+	for name := range dc.failed {
+		if !names[name] {
+			dc.containerFailures.DeleteLabelValues(name)
+			delete(dc.failed, name)
+		}
+	}
+}
+
+// emitContainer sends all the metrics of a single container.
+func (dc *dockerCollector) emitContainer(ch chan<- prometheus.Metric, result *containerResult) {
+	labels := result.labels
+
+	if *collectCommandInfo {
+		command := truncateLabel(result.container.Command, *commandInfoMaxLen)
+		ch <- prometheus.MustNewConstMetric(containerCommandInfoDesc, prometheus.GaugeValue, 1, append(labels, command)...)
+	}
+
+	ch <- prometheus.MustNewConstMetric(containerStateDesc, prometheus.GaugeValue, 1, append(labels, result.container.State)...)
+	ch <- prometheus.MustNewConstMetric(containerCreatedDesc, prometheus.GaugeValue, float64(result.container.Created), labels...)
+	if result.image != nil {
+		ch <- prometheus.MustNewConstMetric(containerImageAgeDesc, prometheus.GaugeValue, time.Since(result.image.created).Seconds(), labels...)
+	}
+
+	if *collectContainerSize {
+		ch <- prometheus.MustNewConstMetric(containerSizeRwDesc, prometheus.GaugeValue, float64(result.container.SizeRw), labels...)
+		ch <- prometheus.MustNewConstMetric(containerSizeRootFsDesc, prometheus.GaugeValue, float64(result.container.SizeRootFs), labels...)
+	} else if dc.diskUsage != nil {
+		// The last background disk usage reading has the writable layer
+		// size without listing the containers with their sizes.
+		if size, ok := dc.diskUsage.containerSizeRw(result.container.ID); ok {
+			ch <- prometheus.MustNewConstMetric(containerSizeRwDesc, prometheus.GaugeValue, float64(size), labels...)
+		}
+	}
+
+	// Restarting containers often have no usable stats, so the inspect
+	// metrics are emitted independently of them.
+	if result.info != nil {
+		emitContainerState(ch, *result.info, labels)
+	}
+
+	for _, resource := range psiResources {
+		for _, line := range result.pressure[resource] {
+			ch <- prometheus.MustNewConstMetric(containerPressureRatioDescs[resource], prometheus.GaugeValue, line.avg10, append(labels, line.kind)...)
+			ch <- prometheus.MustNewConstMetric(containerPressureStalledDescs[resource], prometheus.CounterValue, line.totalSeconds, append(labels, line.kind)...)
+		}
+	}
+
+	if dc.windowed != nil {
+		dc.windowed.emit(ch, result.container.ID, labels)
+	}
+
+	if *collectHealthScore {
+		if score, ok := healthScore(result.info, result.metrics, time.Now()); ok {
+			ch <- prometheus.MustNewConstMetric(containerHealthScoreDesc, prometheus.GaugeValue, score, labels...)
+		}
+	}
+
+	metrics := result.metrics
+	if metrics == nil {
+		return
+	}
+	if !result.sampledAt.IsZero() {
+		ch <- prometheus.MustNewConstMetric(containerStatsSampleTimestampDesc, prometheus.GaugeValue, float64(result.sampledAt.UnixNano())/1e9, labels...)
+	}
+
+	ch <- prometheus.MustNewConstMetric(cpuUsageDesc, prometheus.GaugeValue, metrics.cpuUsagePercent, labels...)
+	if result.info != nil && result.info.HostConfig != nil {
+		if cpus := cpuLimit(result.info.HostConfig); cpus > 0 {
+			ch <- prometheus.MustNewConstMetric(cpuUsageLimitDesc, prometheus.GaugeValue, metrics.cpuUsagePercent/cpus, labels...)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(memoryUsageDesc, prometheus.GaugeValue, float64(metrics.memoryUsageBytes), labels...)
+	ch <- prometheus.MustNewConstMetric(memoryMaxSeenDesc, prometheus.GaugeValue, float64(result.memoryMaxSeen), labels...)
+	if metrics.hasMemoryCache {
+		ch <- prometheus.MustNewConstMetric(memoryCacheDesc, prometheus.GaugeValue, float64(metrics.memoryCacheBytes), labels...)
+	}
+	if metrics.hasMemoryRSS {
+		ch <- prometheus.MustNewConstMetric(memoryRSSDesc, prometheus.GaugeValue, float64(metrics.memoryRSSBytes), labels...)
+	}
+	if metrics.hasMemorySwap {
+		ch <- prometheus.MustNewConstMetric(memorySwapDesc, prometheus.GaugeValue, float64(metrics.memorySwapBytes), labels...)
+	}
+	if result.info != nil {
+		emitLimits(ch, *result.info, metrics, labels)
+	}
+	if cpuUsageSecondsDesc != nil {
+		ch <- prometheus.MustNewConstMetric(cpuUsageSecondsDesc, prometheus.CounterValue, metrics.cpuUsageSeconds, labels...)
+		// Windows only reports the private working set.
+		if metrics.memoryUsageRawBytes > 0 {
+			ch <- prometheus.MustNewConstMetric(memoryUsageRawDesc, prometheus.GaugeValue, float64(metrics.memoryUsageRawBytes), labels...)
+		}
+	}
+
+	// Some platforms don't report the kernel/user split at all; skip the
+	// counters rather than exporting a misleading zero.
+	if metrics.cpuKernelModeSeconds != 0 || metrics.cpuUserModeSeconds != 0 {
+		ch <- prometheus.MustNewConstMetric(cpuKernelModeDesc, prometheus.CounterValue, metrics.cpuKernelModeSeconds, labels...)
+		ch <- prometheus.MustNewConstMetric(cpuUserModeDesc, prometheus.CounterValue, metrics.cpuUserModeSeconds, labels...)
+	}
+
+	if metrics.cpuPeriods > 0 {
+		ch <- prometheus.MustNewConstMetric(cpuPeriodsDesc, prometheus.CounterValue, float64(metrics.cpuPeriods), labels...)
+		ch <- prometheus.MustNewConstMetric(cpuThrottledPeriodsDesc, prometheus.CounterValue, float64(metrics.cpuThrottledPeriods), labels...)
+		ch <- prometheus.MustNewConstMetric(cpuThrottledTimeDesc, prometheus.CounterValue, metrics.cpuThrottledSeconds, labels...)
+	}
+
+	if metrics.hasBlkioOps {
+		ch <- prometheus.MustNewConstMetric(blkioReadOpsDesc, prometheus.CounterValue, float64(metrics.blkioReadOps), labels...)
+		ch <- prometheus.MustNewConstMetric(blkioWriteOpsDesc, prometheus.CounterValue, float64(metrics.blkioWriteOps), labels...)
+	}
+	if metrics.hasBlkioBytes {
+		ch <- prometheus.MustNewConstMetric(blkioReadBytesDesc, prometheus.CounterValue, float64(metrics.blkioReadBytes), labels...)
+		ch <- prometheus.MustNewConstMetric(blkioWriteBytesDesc, prometheus.CounterValue, float64(metrics.blkioWriteBytes), labels...)
+	}
+	emitBlkioPerDevice(ch, metrics.blkioDevices, labels)
+
+	interfaces := make([]string, 0, len(metrics.networks))
+	for name := range metrics.networks {
+		interfaces = append(interfaces, name)
+	}
+	sort.Strings(interfaces)
+	for _, name := range interfaces {
+		network := metrics.networks[name]
+		networkLabels := append(labels, name)
+		ch <- prometheus.MustNewConstMetric(networkRxBytesDesc, prometheus.CounterValue, float64(network.RxBytes), networkLabels...)
+		ch <- prometheus.MustNewConstMetric(networkTxBytesDesc, prometheus.CounterValue, float64(network.TxBytes), networkLabels...)
+		ch <- prometheus.MustNewConstMetric(networkRxPacketsDesc, prometheus.CounterValue, float64(network.RxPackets), networkLabels...)
+		ch <- prometheus.MustNewConstMetric(networkTxPacketsDesc, prometheus.CounterValue, float64(network.TxPackets), networkLabels...)
+		ch <- prometheus.MustNewConstMetric(networkRxDroppedDesc, prometheus.CounterValue, float64(network.RxDropped), networkLabels...)
+		ch <- prometheus.MustNewConstMetric(networkTxDroppedDesc, prometheus.CounterValue, float64(network.TxDropped), networkLabels...)
+	}
+}
+
+// emitSummary sends the host-wide totals and averages over all containers
+// with stats.
+func emitSummary(ch chan<- prometheus.Metric, results []*containerResult) {
+	var count int
+	var cpu, memory float64
+	for _, result := range results {
+		if result.metrics == nil {
 			continue
 		}
+		count++
+		cpu += result.metrics.cpuUsagePercent
+		memory += float64(result.metrics.memoryUsageBytes)
+	}
+
+	ch <- prometheus.MustNewConstMetric(totalCPUUsageDesc, prometheus.GaugeValue, cpu)
+	ch <- prometheus.MustNewConstMetric(totalMemoryUsageDesc, prometheus.GaugeValue, memory)
+	if count > 0 {
+		ch <- prometheus.MustNewConstMetric(averageCPUUsageDesc, prometheus.GaugeValue, cpu/float64(count))
+		ch <- prometheus.MustNewConstMetric(averageMemoryUsageDesc, prometheus.GaugeValue, memory/float64(count))
+	}
+}
+
+// topContainers returns the n results with the highest CPU or memory usage.
+// Containers without stats are never part of the top.
+func topContainers(results []*containerResult, n int, by string) []*containerResult {
+	value := func(m *containerMetrics) float64 {
+		if by == "memory" {
+			return float64(m.memoryUsageBytes)
+		}
+		return m.cpuUsagePercent
+	}
+
+	top := make([]*containerResult, 0, len(results))
+	for _, result := range results {
+		if result.metrics != nil {
+			top = append(top, result)
+		}
+	}
+	sort.SliceStable(top, func(i, j int) bool {
+		return value(top[i].metrics) > value(top[j].metrics)
+	})
+
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+func (dc *dockerCollector) listContainers(ctx context.Context) ([]types.Container, error) {
+	if err := dc.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	// Besides the containers listed by default, include created ones, which
+	// can point at a deployment that failed before its container ever ran,
+	// and the ones stuck in removing or dead, which leak resources until
+	// they are removed by hand.
+	statuses := filters.NewArgs(
+		filters.Arg("status", "created"),
+		filters.Arg("status", "restarting"),
+		filters.Arg("status", "running"),
+		filters.Arg("status", "paused"),
+		filters.Arg("status", "removing"),
+		filters.Arg("status", "dead"),
+	)
+	if *includeExited {
+		statuses.Add("status", "exited")
+	}
+	return dc.dockerClient.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Size:    *collectContainerSize,
+		Filters: statuses,
+	})
+}
+
+func (dc *dockerCollector) inspectContainer(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	if err := dc.limiter.Wait(ctx); err != nil {
+		return types.ContainerJSON{}, err
+	}
+	return dc.dockerClient.ContainerInspect(ctx, containerID)
+}
+
+// emitContainerState sends the metrics derived from inspecting a container.
+func emitContainerState(ch chan<- prometheus.Metric, info types.ContainerJSON, labels []string) {
+	if info.State != nil {
+		restarting := 0.0
+		if info.State.Restarting {
+			restarting = 1
+		}
+		ch <- prometheus.MustNewConstMetric(containerRestartingDesc, prometheus.GaugeValue, restarting, labels...)
+		// RestartCount only ever grows for the lifetime of a container. A
+		// recreated container either gets a new container_id series or, with
+		// -metrics.no-id-label, starts again from 0, which Prometheus detects
+		// as a counter reset.
+		ch <- prometheus.MustNewConstMetric(containerRestartCountDesc, prometheus.CounterValue, float64(info.RestartCount), labels...)
+
+		if latency, ok := startLatency(info.Created, info.State.StartedAt); ok {
+			ch <- prometheus.MustNewConstMetric(containerStartLatencyDesc, prometheus.GaugeValue, latency.Seconds(), labels...)
+		}
+		if started, err := time.Parse(time.RFC3339Nano, info.State.StartedAt); err == nil && !started.IsZero() {
+			ch <- prometheus.MustNewConstMetric(containerStartTimeDesc, prometheus.GaugeValue, float64(started.UnixNano())/1e9, labels...)
+		}
+		// The exit code of a running container is the one of its previous
+		// run, if any, so it is only exported once it stopped.
+		if !info.State.Running {
+			if finished, err := time.Parse(time.RFC3339Nano, info.State.FinishedAt); err == nil && !finished.IsZero() {
+				ch <- prometheus.MustNewConstMetric(containerExitCodeDesc, prometheus.GaugeValue, float64(info.State.ExitCode), labels...)
+			}
+		}
+		if info.State.Health != nil {
+			ch <- prometheus.MustNewConstMetric(containerHealthStatusDesc, prometheus.GaugeValue, 1, append(labels, info.State.Health.Status)...)
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(containerConfigHashDesc, prometheus.GaugeValue, float64(containerConfigHash(info)), labels...)
+
+	if info.HostConfig != nil {
+		collectLogConfigMetrics(ch, info.HostConfig.LogConfig, labels)
+		collectSecurityMetrics(ch, info.HostConfig, labels)
+	}
+
+	if *collectGPU && info.HostConfig != nil {
+		collectGPUMetrics(ch, info.HostConfig.DeviceRequests, labels)
+	}
+}
+
+// collectSecurityMetrics sends whether the container is privileged and the
+// capabilities added to it.
+func collectSecurityMetrics(ch chan<- prometheus.Metric, hostConfig *container.HostConfig, labels []string) {
+	privileged := 0.0
+	if hostConfig.Privileged {
+		privileged = 1
+	}
+	ch <- prometheus.MustNewConstMetric(containerPrivilegedDesc, prometheus.GaugeValue, privileged, labels...)
+	capabilities := addedCapabilities(hostConfig.CapAdd)
+	ch <- prometheus.MustNewConstMetric(containerCapabilitiesAddedDesc, prometheus.GaugeValue, float64(len(capabilities)), labels...)
+
+	if *collectCapabilityInfo {
+		for _, capability := range capabilities {
+			ch <- prometheus.MustNewConstMetric(containerCapabilityInfoDesc, prometheus.GaugeValue, 1, append(labels, capability)...)
+		}
+	}
+}
+
+// addedCapabilities returns the distinct capabilities of --cap-add, sorted.
+// Like the daemon, it accepts them in any case and with or without the CAP_
+// prefix, so NET_ADMIN and cap_net_admin both become CAP_NET_ADMIN.
+func addedCapabilities(capAdd []string) []string {
+	seen := make(map[string]bool, len(capAdd))
+	capabilities := make([]string, 0, len(capAdd))
+	for _, capability := range capAdd {
+		capability = strings.ToUpper(capability)
+		if capability != "ALL" && !strings.HasPrefix(capability, "CAP_") {
+			capability = "CAP_" + capability
+		}
+		if !seen[capability] {
+			seen[capability] = true
+			capabilities = append(capabilities, capability)
+		}
+	}
+	sort.Strings(capabilities)
+	return capabilities
+}
+
+// startLatency returns the time from created to startedAt, both RFC 3339
+// timestamps from inspect. Containers that never started, and negative
+// latencies caused by clock adjustments, are reported as not ok.
+func startLatency(created, startedAt string) (time.Duration, bool) {
+	createdTime, err := time.Parse(time.RFC3339Nano, created)
+	if err != nil {
+		return 0, false
+	}
+	startedTime, err := time.Parse(time.RFC3339Nano, startedAt)
+	if err != nil || startedTime.IsZero() {
+		return 0, false
+	}
+
+	latency := startedTime.Sub(createdTime)
+	if latency < 0 {
+		return 0, false
+	}
+	return latency, true
+}
+
+// containerConfigHash returns a hash of the parts of a container's config that
+// change on a redeploy: the image, the number of environment variables, the
+// labels and the mounts (type, source, destination and mode). Labels and
+// mounts are sorted first so identical configs always hash the same. The
+// 32-bit variant is used as it is exactly representable as a float64.
+func containerConfigHash(info types.ContainerJSON) uint32 {
+	h := fnv.New32a()
+	write := func(s string) {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+
+	if info.Config != nil {
+		write(info.Config.Image)
+		write(strconv.Itoa(len(info.Config.Env)))
+
+		keys := make([]string, 0, len(info.Config.Labels))
+		for k := range info.Config.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			write(k + "=" + info.Config.Labels[k])
+		}
+	}
+
+	mounts := make([]string, 0, len(info.Mounts))
+	for _, m := range info.Mounts {
+		mounts = append(mounts, fmt.Sprintf("%s:%s:%s:%s", m.Type, m.Source, m.Destination, m.Mode))
+	}
+	sort.Strings(mounts)
+	for _, m := range mounts {
+		write(m)
+	}
+
+	return h.Sum32()
+}
+
+// collectLogConfigMetrics emits the log driver of a container along with its
+// rotation settings, when they are configured.
+func collectLogConfigMetrics(ch chan<- prometheus.Metric, logConfig container.LogConfig, labels []string) {
+	if logConfig.Type == "" {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(containerLogDriverDesc, prometheus.GaugeValue, 1, append(labels, logConfig.Type)...)
+
+	if maxSize, ok := logConfig.Config["max-size"]; ok {
+		if bytes, err := units.RAMInBytes(maxSize); err == nil {
+			ch <- prometheus.MustNewConstMetric(containerLogMaxSizeDesc, prometheus.GaugeValue, float64(bytes), labels...)
+		}
+	}
+	if maxFile, ok := logConfig.Config["max-file"]; ok {
+		if files, err := strconv.Atoi(maxFile); err == nil {
+			ch <- prometheus.MustNewConstMetric(containerLogMaxFilesDesc, prometheus.GaugeValue, float64(files), labels...)
+		}
+	}
+}
+
+// cachedLabelValues returns the label values of a container, computing them
+// only when the container is new, was restarted or renamed. Containers that
+// couldn't be inspected aren't cached, since their env labels are missing.
+func (dc *dockerCollector) cachedLabelValues(container types.Container, info *types.ContainerJSON) []string {
+	if info == nil || info.State == nil {
+		return dc.containerLabelValues(container, info)
+	}
+
+	token := info.State.StartedAt + "/" + strings.Join(container.Names, ",")
+	dc.labelMu.Lock()
+	cached, ok := dc.labelCache[container.ID]
+	dc.labelMu.Unlock()
+	if ok && cached.token == token {
+		return cached.values
+	}
+	// Limit the capacity so that appending metric specific labels copies
+	// rather than writes into the cached slice.
+	values := dc.containerLabelValues(container, info)
+	values = values[:len(values):len(values)]
+	dc.labelMu.Lock()
+	dc.labelCache[container.ID] = cachedLabels{token: token, values: values}
+	dc.labelMu.Unlock()
+	return values
+}
+
+// containerLabelValues returns the values for containerLabelNames, in order.
+// info may be nil when inspecting the container failed.
+func (dc *dockerCollector) containerLabelValues(container types.Container, info *types.ContainerJSON) []string {
+	var values []string
+	switch {
+	case *metricsCompat == "cadvisor":
+		values = cadvisorLabelValues(container.ID, containerName(container), container.Image)
+	case *noIDLabel:
+		repository, tag := parseImageReference(container.Image)
+		values = []string{
+			containerName(container), repository, tag,
+			container.Labels[composeServiceLabel], container.Labels[composeContainerNumberLabel],
+		}
+	default:
+		values = []string{container.ID, containerName(container), container.Image}
+	}
+	if dc.labelTemplate != nil {
+		values = append(values, dc.executeLabelTemplate(container))
+	}
+	for _, name := range dc.allowedLabels {
+		values = append(values, container.Labels[name])
+	}
+	if len(dc.exposeEnv) > 0 {
+		var env []string
+		if info != nil && info.Config != nil {
+			env = info.Config.Env
+		}
+		for _, name := range dc.exposeEnv {
+			values = append(values, lookupEnv(env, name))
+		}
+	}
+	return values
+}
+
+// envLabelName turns an environment variable name into a label name, e.g.
+// APP_VERSION becomes env_app_version.
+func envLabelName(name string) string {
+	return sanitizeLabelName("env_", name)
+}
+
+// dockerLabelName turns a Docker label name into a label name, e.g.
+// com.docker.compose.service becomes label_com_docker_compose_service.
+func dockerLabelName(name string) string {
+	return sanitizeLabelName("label_", name)
+}
+
+// sanitizeLabelName lower cases name and replaces the characters not allowed
+// in a Prometheus label name with underscores.
+func sanitizeLabelName(prefix, name string) string {
+	var b strings.Builder
+	b.WriteString(prefix)
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// lookupEnv returns the value of name in a list of KEY=VALUE pairs, or an
+// empty string when it is not set.
+func lookupEnv(env []string, name string) string {
+	for _, kv := range env {
+		if k, v, ok := strings.Cut(kv, "="); ok && k == name {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseImageReference splits an image reference into its repository and tag,
+// e.g. registry.io:5000/app:1.2 into registry.io:5000/app and 1.2. The tag
+// defaults to latest. Digest pinned references get the digest as their tag
+// unless they also have one, and bare image IDs have no repository.
+func parseImageReference(ref string) (repository, tag string) {
+	if strings.HasPrefix(ref, "sha256:") {
+		return "", ref
+	}
+
+	name, digest, pinned := strings.Cut(ref, "@")
+
+	// A colon after the last slash separates the tag, one before it is part
+	// of a registry host:port.
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		return name[:i], name[i+1:]
+	}
+	if pinned {
+		return name, digest
+	}
+	return name, "latest"
+}
+
+// containerName returns the primary name of a container, without the leading
+// slash Docker reports.
+func containerName(container types.Container) string {
+	if len(container.Names) == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(container.Names[0], "/")
+}
+
+// truncateLabel shortens value to at most max bytes without splitting a
+// multi-byte character.
+func truncateLabel(value string, max int) string {
+	if max <= 0 || len(value) <= max {
+		return value
+	}
+	for max > 0 && !utf8.RuneStart(value[max]) {
+		max--
+	}
+	return value[:max]
+}
+
+// executeLabelTemplate renders the service label for a container. Failures
+// fall back to an empty value and are only logged the first time they happen.
+func (dc *dockerCollector) executeLabelTemplate(container types.Container) string {
+	data := containerTemplateData{
+		ID:     container.ID,
+		Name:   containerName(container),
+		Image:  container.Image,
+		Labels: container.Labels,
+	}
 
-		ch <- prometheus.MustNewConstMetric(cpuUsageDesc, prometheus.GaugeValue, cpuUsagePercent, container.ID)
-		ch <- prometheus.MustNewConstMetric(memoryUsageDesc, prometheus.GaugeValue, float64(memoryUsageBytes), container.ID)
+	var buf strings.Builder
+	if err := dc.labelTemplate.Execute(&buf, data); err != nil {
+		dc.templateErrOnce.Do(func() {
+			log.Println("Failed to execute label template for container", container.ID, ":", err)
+		})
+		return ""
 	}
+	return buf.String()
 }
 
-func (dc *dockerCollector) getContainerMetrics(containerID string) (float64, uint64, error) {
-	stats, err := dc.dockerClient.ContainerStats(context.Background(), containerID, false)
+func (dc *dockerCollector) getContainerMetrics(ctx context.Context, containerID string) (*containerMetrics, error) {
+	if err := dc.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	stream := *cpuSamples > 1
+	stats, err := dc.dockerClient.ContainerStats(ctx, containerID, stream)
 	if err != nil {
-		return 0, 0, err
+		return nil, err
 	}
 	defer stats.Body.Close()
 
-	var statData types.StatsJSON
-	if err := json.NewDecoder(stats.Body).Decode(&statData); err != nil {
-		return 0, 0, err
+	body := &maxBytesReader{r: stats.Body, n: *maxStatsBytes}
+	var statData *types.StatsJSON
+	var cpuUsagePercent float64
+	if stream {
+		statData, cpuUsagePercent, err = decodeSampledStats(body, *cpuSamples, stats.OSType)
+	} else {
+		statData, err = decodeLatestStats(body)
+		if err == nil {
+			cpuUsagePercent = cpuPercent(statData, stats.OSType)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newContainerMetrics(statData, stats.OSType, cpuUsagePercent)
+}
+
+// newContainerMetrics converts a stats sample with the given CPU usage.
+func newContainerMetrics(statData *types.StatsJSON, osType string, cpuUsagePercent float64) (*containerMetrics, error) {
+	// Some container states get a 200 with an empty object, which would
+	// otherwise be reported as 0% CPU and no memory.
+	if statData.CPUStats.CPUUsage.TotalUsage == 0 && statData.Read.IsZero() {
+		return nil, errEmptyStats
+	}
+
+	cpuUsageUnrounded := cpuUsagePercent
+	if *cpuPrecision >= 0 {
+		cpuUsagePercent = roundTo(cpuUsagePercent, *cpuPrecision)
+	}
+
+	// Memory usage in bytes. Windows has no usage and cache figures, only the
+	// private working set.
+	var memoryUsageBytes uint64
+	var cache, rss, swap uint64
+	var hasCache, hasRSS, hasSwap bool
+	if osType == "windows" {
+		memoryUsageBytes = statData.MemoryStats.PrivateWorkingSet
+	} else {
+		memoryUsageBytes = memoryUsage(statData.MemoryStats, *memoryExcludeKernel)
+		cache, hasCache = memoryStat(statData.MemoryStats.Stats, "total_cache", "file", "cache")
+		rss, hasRSS = memoryStat(statData.MemoryStats.Stats, "total_rss", "anon", "rss")
+		swap, hasSwap = memoryStat(statData.MemoryStats.Stats, "total_swap", "swap")
+	}
+
+	// Kernel and user mode CPU time, reported by Docker in nanoseconds
+	cpuKernelModeSeconds := float64(statData.CPUStats.CPUUsage.UsageInKernelmode) / 1e9
+	cpuUserModeSeconds := float64(statData.CPUStats.CPUUsage.UsageInUsermode) / 1e9
+
+	// CPU throttling, the throttled time is reported in nanoseconds
+	throttling := statData.CPUStats.ThrottlingData
+	cpuThrottledInSample := throttling.ThrottledPeriods > statData.PreCPUStats.ThrottlingData.ThrottledPeriods
+
+	// Block I/O operations and bytes, summed across devices
+	blkioReadOps, blkioWriteOps := sumBlkio(statData.BlkioStats.IoServicedRecursive)
+	blkioReadBytes, blkioWriteBytes := sumBlkio(statData.BlkioStats.IoServiceBytesRecursive)
+
+	var blkioDevices []blkioDevice
+	if *blkioPerDevice {
+		blkioDevices = blkioPerDeviceBytes(statData.BlkioStats.IoServiceBytesRecursive)
+	}
+
+	return &containerMetrics{
+		cpuUsagePercent:      cpuUsagePercent,
+		cpuUsageUnrounded:    cpuUsageUnrounded,
+		cpuKernelModeSeconds: cpuKernelModeSeconds,
+		cpuUserModeSeconds:   cpuUserModeSeconds,
+		memoryUsageBytes:     memoryUsageBytes,
+		cpuUsageSeconds:      float64(statData.CPUStats.CPUUsage.TotalUsage) / 1e9,
+		memoryUsageRawBytes:  statData.MemoryStats.Usage,
+		hasMemoryCache:       hasCache,
+		memoryCacheBytes:     cache,
+		hasMemoryRSS:         hasRSS,
+		memoryRSSBytes:       rss,
+		hasMemorySwap:        hasSwap,
+		memorySwapBytes:      swap,
+		memoryLimitBytes:     statData.MemoryStats.Limit,
+		pidsLimit:            statData.PidsStats.Limit,
+		cpuPeriods:           throttling.Periods,
+		cpuThrottledPeriods:  throttling.ThrottledPeriods,
+		cpuThrottledSeconds:  float64(throttling.ThrottledTime) / 1e9,
+		cpuThrottledInSample: cpuThrottledInSample,
+		hasBlkioOps:          len(statData.BlkioStats.IoServicedRecursive) > 0,
+		blkioReadOps:         blkioReadOps,
+		blkioWriteOps:        blkioWriteOps,
+		blkioDevices:         blkioDevices,
+		hasBlkioBytes:        len(statData.BlkioStats.IoServiceBytesRecursive) > 0,
+		blkioReadBytes:       blkioReadBytes,
+		blkioWriteBytes:      blkioWriteBytes,
+		networks:             statData.Networks,
+	}, nil
+}
+
+// roundTo rounds v to the given number of decimal places.
+func roundTo(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(v*scale) / scale
+}
+
+// memoryUsage returns the working set in bytes, the usage without the inactive
+// page cache, which the kernel reclaims first. It is what docker stats shows.
+// With excludeKernel the kernel memory reported on cgroup v2 is subtracted too.
+func memoryUsage(mem types.MemoryStats, excludeKernel bool) uint64 {
+	inactive, ok := memoryStat(mem.Stats, "total_inactive_file", "inactive_file")
+	if !ok {
+		// Daemons too old to report the inactive page cache only have the
+		// whole of it.
+		inactive = mem.Stats["cache"]
+	}
+	usage := subtractStat(mem.Usage, inactive)
+	if !excludeKernel {
+		return usage
+	}
+
+	// "kernel" already includes the kernel stack and slab on kernels that
+	// report it, so only fall back to the individual keys without it. Socket
+	// buffers are kernel memory as well, but never part of "kernel".
+	usage = subtractStat(usage, mem.Stats["sock"])
+	if kernel, ok := mem.Stats["kernel"]; ok {
+		return subtractStat(usage, kernel)
 	}
+	usage = subtractStat(usage, mem.Stats["kernel_stack"])
+	return subtractStat(usage, mem.Stats["slab"])
+}
 
-	// Calculate CPU usage percentage
+// memoryStat returns the first of keys found in the memory stats. cgroup v1
+// and v2 name the same figures differently: v1 reports the totals over the
+// cgroup hierarchy with a total_ prefix, next to the values of the container's
+// own cgroup, while v2 has no prefix and other names, e.g. file for cache and
+// anon for rss. So the keys are given as v1 total, v2, v1 own cgroup.
+func memoryStat(stats map[string]uint64, keys ...string) (uint64, bool) {
+	for _, key := range keys {
+		if value, ok := stats[key]; ok {
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+// subtractStat returns a-b, clamped to 0 instead of wrapping around.
+func subtractStat(a, b uint64) uint64 {
+	if b > a {
+		return 0
+	}
+	return a - b
+}
+
+// sumBlkio adds up the read and write values of blkio entries across all
+// devices. The op names are capitalised on cgroup v1 and lower case on v2.
+func sumBlkio(entries []types.BlkioStatEntry) (read, write uint64) {
+	for _, entry := range entries {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			read += entry.Value
+		case "write":
+			write += entry.Value
+		}
+	}
+	return read, write
+}
+
+// rereadContainerMetrics takes one more sample after a short pause, for when
+// Docker returned identical pre and post CPU readings. The original sample is
+// kept if the second read fails.
+func (dc *dockerCollector) rereadContainerMetrics(ctx context.Context, containerID string, metrics *containerMetrics) *containerMetrics {
+	select {
+	case <-time.After(zeroDeltaRereadPause):
+	case <-ctx.Done():
+		return metrics
+	}
+
+	reread, err := dc.getContainerMetrics(ctx, containerID)
+	if err != nil {
+		return metrics
+	}
+	return reread
+}
+
+// cpuPercent returns the CPU usage between the two readings of a stats
+// sample in percent, where 100 is one fully used host CPU.
+func cpuPercent(statData *types.StatsJSON, osType string) float64 {
+	if osType == "windows" {
+		return windowsCPUPercent(statData)
+	}
 	cpuDelta := float64(statData.CPUStats.CPUUsage.TotalUsage - statData.PreCPUStats.CPUUsage.TotalUsage)
 	systemDelta := float64(statData.CPUStats.SystemUsage - statData.PreCPUStats.SystemUsage)
-	cpuUsagePercent := (cpuDelta / systemDelta) * float64(len(statData.CPUStats.CPUUsage.PercpuUsage)) * 100.0
+	if systemDelta <= 0 {
+		return 0
+	}
+	// The per CPU usage is only reported on cgroup v1, and daemons before
+	// API 1.27 don't report the online CPUs.
+	cpus := float64(statData.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(statData.CPUStats.CPUUsage.PercpuUsage))
+	}
+	return (cpuDelta / systemDelta) * cpus * 100.0
+}
 
-	// Memory usage in bytes
-	memoryUsageBytes := statData.MemoryStats.Usage - statData.MemoryStats.Stats["cache"]
+// windowsCPUPercent is cpuPercent for Windows, which doesn't report the system
+// CPU usage. The CPU usage, counted in 100ns intervals, is compared with the
+// time between the two readings instead.
+func windowsCPUPercent(statData *types.StatsJSON) float64 {
+	if statData.PreRead.IsZero() {
+		return 0
+	}
+	intervals := float64(statData.Read.Sub(statData.PreRead).Nanoseconds()) / 100
+	if intervals <= 0 {
+		return 0
+	}
+	cpuDelta := float64(statData.CPUStats.CPUUsage.TotalUsage - statData.PreCPUStats.CPUUsage.TotalUsage)
+	return cpuDelta / intervals * 100.0
+}
 
-	return cpuUsagePercent, memoryUsageBytes, nil
+// hasPreviousReading reports whether a stats frame has the previous reading
+// the CPU usage is computed from, which the first frame of a stream lacks.
+func hasPreviousReading(statData *types.StatsJSON, osType string) bool {
+	if osType == "windows" {
+		return !statData.PreRead.IsZero()
+	}
+	return statData.PreCPUStats.SystemUsage != 0
+}
+
+// decodeSampledStats reads frames from a stats stream until it has n CPU
+// readings, and returns the last frame along with their average CPU usage.
+// The first frame of a stream has no previous reading to compare with, so
+// it doesn't count. The daemon sends a frame about every second.
+func decodeSampledStats(r io.Reader, n int, osType string) (*types.StatsJSON, float64, error) {
+	decoder := json.NewDecoder(r)
+
+	var latest *types.StatsJSON
+	var sum float64
+	for samples := 0; samples < n; {
+		var frame types.StatsJSON
+		if err := decoder.Decode(&frame); err != nil {
+			if err == io.EOF && latest == nil {
+				return nil, 0, errEmptyStats
+			}
+			return nil, 0, err
+		}
+		latest = &frame
+		if !hasPreviousReading(&frame, osType) {
+			continue
+		}
+		sum += cpuPercent(&frame, osType)
+		samples++
+	}
+	return latest, sum / float64(n), nil
+}
+
+// maxBytesReader reads from r, failing with errStatsTooLarge once more than n
+// bytes have been read. Unlike io.LimitReader it doesn't pass as a clean EOF,
+// which would let a truncated response decode.
+type maxBytesReader struct {
+	r io.Reader
+	n int64
+}
+
+func (mr *maxBytesReader) Read(p []byte) (int, error) {
+	if mr.n < 0 {
+		return 0, errStatsTooLarge
+	}
+	// Allow one byte past the limit to tell a response of exactly n bytes
+	// from a larger one.
+	if int64(len(p)) > mr.n+1 {
+		p = p[:mr.n+1]
+	}
+	n, err := mr.r.Read(p)
+	mr.n -= int64(n)
+	if mr.n < 0 {
+		return n, errStatsTooLarge
+	}
+	return n, err
+}
+
+// decodeLatestStats reads successive stats frames from r until EOF and returns
+// the last complete one. A truncated trailing frame is dropped rather than
+// returned half-filled, which would otherwise report zeroed metrics.
+//
+// Whitespace around and between frames, such as the trailing newline some
+// daemons send, is skipped by the decoder. A body that is empty or only
+// whitespace, e.g. with Content-Length: 0, yields errEmptyStats, so the
+// container is skipped like any other container without a sample.
+func decodeLatestStats(r io.Reader) (*types.StatsJSON, error) {
+	decoder := json.NewDecoder(r)
+
+	var latest *types.StatsJSON
+	for {
+		var frame types.StatsJSON
+		err := decoder.Decode(&frame)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if latest != nil && errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return nil, err
+		}
+		latest = &frame
+	}
+
+	if latest == nil {
+		return nil, errEmptyStats
+	}
+	return latest, nil
 }
 
 func main() {
-	dc, err := newDockerCollector()
+	flag.Parse()
+	if err := applyEnv(); err != nil {
+		log.Fatal("Error applying environment:", err)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal("Error loading config file:", err)
+	}
+	initDescs()
+
+	daemons, err := newDaemons()
 	if err != nil {
 		log.Fatal("Error creating Docker collector:", err)
 	}
+	var dcs []*dockerCollector
+	for _, d := range daemons {
+		dcs = append(dcs, d.collector)
+	}
+	if err := applySettings(dcs); err != nil {
+		log.Fatal("Error applying settings:", err)
+	}
+	if err := checkConstantLabels(daemons); err != nil {
+		log.Fatal("Error applying settings:", err)
+	}
+	if *collectWindowedStats {
+		for _, dc := range dcs {
+			dc.windowed = newWindowedStats(dc)
+			dc.windowed.start()
+		}
+	}
+	if *collectStatsStreams {
+		for _, dc := range dcs {
+			dc.streams = newStatsStreams(dc)
+			dc.streams.start()
+		}
+	}
+
+	// Everything is registered through a wrapping registerer so that the
+	// constant labels end up on every metric, including the runtime ones.
+	registry := prometheus.NewRegistry()
+	registerer := prometheus.WrapRegistererWith(prometheus.Labels(constantLabels), registry)
+	if *collectGo {
+		registerer.MustRegister(collectors.NewGoCollector())
+	}
+	if *collectProcess {
+		registerer.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	}
+
+	registerer.MustRegister(newConfigInfo())
+	for _, d := range daemons {
+		d.register(registerer)
+	}
 
-	prometheus.MustRegister(dc)
+	if *pushGatewayURL != "" {
+		runPush(registry)
+		return
+	}
+
+	cfg.watchReloadSignal(dcs)
+
+	// In snapshot mode the handler's own metrics and the refresh timestamp
+	// live in a separate registry that is gathered on every scrape.
+	var gatherer prometheus.Gatherer = registry
+	if *refreshInterval > 0 {
+		live := prometheus.NewRegistry()
+		registerer = prometheus.WrapRegistererWith(prometheus.Labels(constantLabels), live)
+
+		snapshot := newSnapshotGatherer(registry, registerer)
+		snapshot.start(*refreshInterval)
+		gatherer = prometheus.Gatherers{snapshot, live}
+	}
+
+	// The ping result is registered with the live registry, so that it isn't
+	// delayed by the snapshot.
+	if *pingInterval > 0 {
+		for _, d := range daemons {
+			dc := d.collector
+			newDaemonPinger(dc.dockerClient, dc.limiter, d.wrap(registerer)).start(*pingInterval)
+		}
+	}
 
-	http.Handle("/metrics", promhttp.Handler())
-	log.Fatal(http.ListenAndServe(":924", nil))
+	mux := http.NewServeMux()
+	mux.Handle(*telemetryPath, promhttp.InstrumentMetricHandler(
+		registerer, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}),
+	))
+	mux.HandleFunc("/-/reload", cfg.reloadHandler(dcs))
+	mux.HandleFunc("/healthz", healthzHandler(dcs))
+	if *telemetryPath != "/" {
+		page, err := landingPage()
+		if err != nil {
+			log.Fatal("Error creating landing page:", err)
+		}
+		mux.Handle("/", page)
+	}
+	if *enableDebug {
+		mux.Handle("/containers", inventoryHandler(daemons))
+	}
+	if err := serve(mux, listenAddresses); err != nil {
+		log.Fatal(err)
+	}
 }
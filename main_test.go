@@ -0,0 +1,388 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	dto "github.com/prometheus/client_model/go"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// statsFrame returns a stats frame whose total CPU usage identifies it.
+func statsFrame(totalUsage int) string {
+	return fmt.Sprintf(`{"read":"2024-01-01T00:00:0%dZ","cpu_stats":{"cpu_usage":{"total_usage":%d}}}`, totalUsage, totalUsage)
+}
+
+func TestDecodeLatestStatsFrames(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames int
+	}{
+		{"one frame", 1},
+		{"two frames", 2},
+		{"three frames", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body strings.Builder
+			for i := 1; i <= tt.frames; i++ {
+				body.WriteString(statsFrame(i))
+			}
+
+			stats, err := decodeLatestStats(strings.NewReader(body.String()))
+			if err != nil {
+				t.Fatalf("decodeLatestStats() error = %v", err)
+			}
+			if got := stats.CPUStats.CPUUsage.TotalUsage; got != uint64(tt.frames) {
+				t.Errorf("decodeLatestStats() returned frame %d, want the last frame %d", got, tt.frames)
+			}
+		})
+	}
+}
+
+func TestDecodeLatestStatsTruncatedFrame(t *testing.T) {
+	body := statsFrame(1) + statsFrame(2)[:20]
+
+	stats, err := decodeLatestStats(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("decodeLatestStats() error = %v", err)
+	}
+	if got := stats.CPUStats.CPUUsage.TotalUsage; got != 1 {
+		t.Errorf("decodeLatestStats() returned frame %d, want the last complete frame 1", got)
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	tests := []struct {
+		name          string
+		usage         uint64
+		stats         map[string]uint64
+		excludeKernel bool
+		want          uint64
+	}{
+		{
+			name:  "v1 subtracts the inactive page cache",
+			usage: 1000,
+			stats: map[string]uint64{"cache": 300, "total_inactive_file": 200, "inactive_file": 150},
+			want:  800,
+		},
+		{
+			name:  "v2 subtracts the inactive page cache",
+			usage: 1000,
+			stats: map[string]uint64{"file": 300, "inactive_file": 200, "kernel": 100, "sock": 50},
+			want:  800,
+		},
+		{
+			name:          "v2 excludes kernel and sock",
+			usage:         1000,
+			stats:         map[string]uint64{"inactive_file": 200, "kernel": 100, "kernel_stack": 20, "slab": 60, "sock": 50},
+			excludeKernel: true,
+			want:          650,
+		},
+		{
+			name:          "v2 without kernel excludes kernel stack, slab and sock",
+			usage:         1000,
+			stats:         map[string]uint64{"inactive_file": 200, "kernel_stack": 20, "slab": 60, "sock": 50},
+			excludeKernel: true,
+			want:          670,
+		},
+		{
+			name:          "v1 has no kernel keys to exclude",
+			usage:         1000,
+			stats:         map[string]uint64{"cache": 300, "total_inactive_file": 200},
+			excludeKernel: true,
+			want:          800,
+		},
+		{
+			name:  "inactive page cache larger than usage clamps to 0",
+			usage: 100,
+			stats: map[string]uint64{"inactive_file": 200},
+			want:  0,
+		},
+		{
+			name:          "kernel larger than usage clamps to 0",
+			usage:         1000,
+			stats:         map[string]uint64{"inactive_file": 200, "kernel": 5000},
+			excludeKernel: true,
+			want:          0,
+		},
+		{
+			name:          "slab and sock larger than usage clamp to 0",
+			usage:         1000,
+			stats:         map[string]uint64{"slab": 900, "sock": 900},
+			excludeKernel: true,
+			want:          0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := types.MemoryStats{Usage: tt.usage, Stats: tt.stats}
+			if got := memoryUsage(mem, tt.excludeKernel); got != tt.want {
+				t.Errorf("memoryUsage() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// windowsStats is a Windows stats sample, with the CPU usage in 100ns
+// intervals and no system CPU usage or Linux memory figures.
+const windowsStats = `{
+	"read": "2024-01-01T00:00:02Z",
+	"preread": "2024-01-01T00:00:01Z",
+	"num_procs": 4,
+	"cpu_stats": {"cpu_usage": {"total_usage": 15000000, "usage_in_kernelmode": 4000000, "usage_in_usermode": 11000000}},
+	"precpu_stats": {"cpu_usage": {"total_usage": 10000000, "usage_in_kernelmode": 3000000, "usage_in_usermode": 7000000}},
+	"memory_stats": {"commitbytes": 300000000, "commitpeakbytes": 400000000, "privateworkingset": 200000000}
+}`
+
+func TestNewContainerMetricsWindows(t *testing.T) {
+	statData, err := decodeLatestStats(strings.NewReader(windowsStats))
+	if err != nil {
+		t.Fatalf("decodeLatestStats() error = %v", err)
+	}
+
+	metrics, err := newContainerMetrics(statData, "windows", cpuPercent(statData, "windows"))
+	if err != nil {
+		t.Fatalf("newContainerMetrics() error = %v", err)
+	}
+	// 5000000 intervals of 100ns in one second is half a CPU.
+	if metrics.cpuUsagePercent != 50 {
+		t.Errorf("cpuUsagePercent = %v, want 50", metrics.cpuUsagePercent)
+	}
+	if metrics.memoryUsageBytes != 200000000 {
+		t.Errorf("memoryUsageBytes = %d, want the private working set 200000000", metrics.memoryUsageBytes)
+	}
+	if metrics.hasMemoryCache || metrics.hasMemoryRSS || metrics.hasMemorySwap {
+		t.Errorf("Windows stats have a memory breakdown: cache %v, rss %v, swap %v", metrics.hasMemoryCache, metrics.hasMemoryRSS, metrics.hasMemorySwap)
+	}
+}
+
+func TestDecodeSampledStatsWindows(t *testing.T) {
+	// The first frame of a stream has no previous reading.
+	first := `{"read": "2024-01-01T00:00:01Z", "num_procs": 4, "cpu_stats": {"cpu_usage": {"total_usage": 10000000}}}`
+	body := first + windowsStats + strings.Replace(windowsStats, "15000000", "20000000", 1)
+
+	statData, cpu, err := decodeSampledStats(strings.NewReader(body), 2, "windows")
+	if err != nil {
+		t.Fatalf("decodeSampledStats() error = %v", err)
+	}
+	if cpu != 75 {
+		t.Errorf("decodeSampledStats() CPU = %v, want the average of 50 and 100", cpu)
+	}
+	if statData.CPUStats.CPUUsage.TotalUsage != 20000000 {
+		t.Errorf("decodeSampledStats() returned frame %d, want the last one", statData.CPUStats.CPUUsage.TotalUsage)
+	}
+}
+
+// bodyMetrics decodes a stats body and converts it like getContainerMetrics.
+func bodyMetrics(body string) (*containerMetrics, error) {
+	statData, err := decodeLatestStats(strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return newContainerMetrics(statData, "linux", cpuPercent(statData, "linux"))
+}
+
+func TestEmptyStats(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"empty body", ""},
+		{"empty object", "{}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics, err := bodyMetrics(tt.body)
+			if !errors.Is(err, errEmptyStats) {
+				t.Errorf("bodyMetrics(%q) = %v, %v, want errEmptyStats", tt.body, metrics, err)
+			}
+		})
+	}
+}
+
+func TestParseImageReference(t *testing.T) {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		ref            string
+		wantRepository string
+		wantTag        string
+	}{
+		{"nginx:1.25", "nginx", "1.25"},
+		{"nginx", "nginx", "latest"},
+		{"library/nginx:1.25", "library/nginx", "1.25"},
+		{"docker.io/library/nginx", "docker.io/library/nginx", "latest"},
+		{"registry.io:5000/team/app:1.2", "registry.io:5000/team/app", "1.2"},
+		{"registry.io:5000/team/app", "registry.io:5000/team/app", "latest"},
+		{"registry.io/app@" + digest, "registry.io/app", digest},
+		{"registry.io:5000/app:1.2@" + digest, "registry.io:5000/app", "1.2"},
+		{digest, "", digest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			repository, tag := parseImageReference(tt.ref)
+			if repository != tt.wantRepository || tag != tt.wantTag {
+				t.Errorf("parseImageReference(%q) = %q, %q, want %q, %q", tt.ref, repository, tag, tt.wantRepository, tt.wantTag)
+			}
+		})
+	}
+}
+
+// setTestFlag sets a flag for the duration of the test and rebuilds the
+// descriptors, whose labels can depend on it.
+func setTestFlag(t *testing.T, name, value string) {
+	previous := flag.Lookup(name).Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatalf("flag.Set(%q, %q) error = %v", name, value, err)
+	}
+	initDescs()
+	t.Cleanup(func() {
+		flag.Set(name, previous)
+		initDescs()
+	})
+}
+
+// restartCounts returns docker_exporter_container_restart_count by the
+// container_id and name labels.
+func restartCounts(families []*dto.MetricFamily) map[[2]string]float64 {
+	counts := map[[2]string]float64{}
+	for _, mf := range families {
+		if mf.GetName() != "docker_exporter_container_restart_count" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			id, _ := labelValue(m, "container_id")
+			name, _ := labelValue(m, "name")
+			counts[[2]string{id, name}] = m.GetCounter().GetValue()
+		}
+	}
+	return counts
+}
+
+func TestRestartCountRecreatedContainer(t *testing.T) {
+	oldID, newID := fmt.Sprintf("%064d", 1), fmt.Sprintf("%064d", 2)
+	tests := []struct {
+		name      string
+		noIDLabel bool
+		wantOld   map[[2]string]float64
+		wantNew   map[[2]string]float64
+	}{
+		{
+			name:    "new container_id series",
+			wantOld: map[[2]string]float64{{oldID, "web"}: 5},
+			wantNew: map[[2]string]float64{{newID, "web"}: 0},
+		},
+		{
+			name:      "counter reset without container_id",
+			noIDLabel: true,
+			wantOld:   map[[2]string]float64{{"", "web"}: 5},
+			wantNew:   map[[2]string]float64{{"", "web"}: 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fd := newFakeDaemon(t)
+			fd.setContainers(fakeContainer{id: oldID, name: "web", restartCount: 5})
+			setDockerHosts(t, fd.host())
+			setTestFlag(t, "metrics.no-id-label", strconv.FormatBool(tt.noIDLabel))
+			reg := registerDaemons(t)
+
+			if got := restartCounts(gather(t, reg)); !reflect.DeepEqual(got, tt.wantOld) {
+				t.Errorf("restart_count before recreation = %v, want %v", got, tt.wantOld)
+			}
+			fd.setContainers(fakeContainer{id: newID, name: "web"})
+			if got := restartCounts(gather(t, reg)); !reflect.DeepEqual(got, tt.wantNew) {
+				t.Errorf("restart_count after recreation = %v, want %v", got, tt.wantNew)
+			}
+		})
+	}
+}
+
+func TestRoundTo(t *testing.T) {
+	tests := []struct {
+		v      float64
+		places int
+		want   float64
+	}{
+		{37.418239, 2, 37.42},
+		{37.418239, 0, 37},
+		{37.5, 0, 38},
+		{0.004, 2, 0},
+		{0.005, 2, 0.01},
+		{123.456, -1, 120},
+	}
+	for _, tt := range tests {
+		if got := roundTo(tt.v, tt.places); got != tt.want {
+			t.Errorf("roundTo(%v, %d) = %v, want %v", tt.v, tt.places, got, tt.want)
+		}
+	}
+}
+
+func TestCPUPrecision(t *testing.T) {
+	setTestFlag(t, "metrics.cpu-precision", "1")
+
+	metrics, err := newContainerMetrics(&types.StatsJSON{Stats: types.Stats{Read: time.Now()}}, "linux", 0.0123)
+	if err != nil {
+		t.Fatalf("newContainerMetrics() error = %v", err)
+	}
+	if metrics.cpuUsagePercent != 0 {
+		t.Errorf("cpuUsagePercent = %v, want 0.0123 rounded to 0", metrics.cpuUsagePercent)
+	}
+	// A small usage that rounds to 0 still had a CPU delta, so it must not
+	// trigger -docker.reread-on-zero-delta.
+	if metrics.cpuUsageUnrounded != 0.0123 {
+		t.Errorf("cpuUsageUnrounded = %v, want 0.0123", metrics.cpuUsageUnrounded)
+	}
+}
+
+func TestDecodeLatestStatsWhitespace(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want uint64
+	}{
+		{"trailing newline", statsFrame(1) + "\n", 1},
+		{"trailing whitespace", statsFrame(1) + " \r\n\t\n", 1},
+		{"leading whitespace", "\n  \t" + statsFrame(1), 1},
+		{"newline delimited frames", statsFrame(1) + "\n" + statsFrame(2) + "\n", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats, err := decodeLatestStats(strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("decodeLatestStats(%q) error = %v", tt.body, err)
+			}
+			if got := stats.CPUStats.CPUUsage.TotalUsage; got != tt.want {
+				t.Errorf("decodeLatestStats(%q) returned frame %d, want %d", tt.body, got, tt.want)
+			}
+		})
+	}
+
+	for _, body := range []string{"\n", " \r\n\t"} {
+		if _, err := decodeLatestStats(strings.NewReader(body)); !errors.Is(err, errEmptyStats) {
+			t.Errorf("decodeLatestStats(%q) error = %v, want errEmptyStats", body, err)
+		}
+	}
+}
+
+func TestAddedCapabilities(t *testing.T) {
+	tests := []struct {
+		capAdd []string
+		want   []string
+	}{
+		{nil, []string{}},
+		{[]string{"NET_ADMIN"}, []string{"CAP_NET_ADMIN"}},
+		{[]string{"SYS_TIME", "CAP_NET_ADMIN", "NET_ADMIN", "net_admin"}, []string{"CAP_NET_ADMIN", "CAP_SYS_TIME"}},
+		{[]string{"ALL", "all"}, []string{"ALL"}},
+	}
+	for _, tt := range tests {
+		if got := addedCapabilities(tt.capAdd); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("addedCapabilities(%q) = %q, want %q", tt.capAdd, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	collectNetworks  = flag.Bool("collector.networks", false, "Export inventory metrics for Docker networks.")
+	networksCacheTTL = flag.Duration("collector.networks.cache-ttl", time.Minute, "How long the connected container count of a network is cached before it is inspected again.")
+)
+
+// networksTimeout bounds the network API calls of a scrape, so that an
+// unresponsive daemon doesn't leave a blocked collection behind every scrape.
+const networksTimeout = 10 * time.Second
+
+// cachedNetworkCount is a connected container count from NetworkInspect.
+type cachedNetworkCount struct {
+	containers  int
+	inspectedAt time.Time
+}
+
+// networkCollector exports the Docker networks of the host. NetworkList does
+// not include the connected containers, so each network is inspected as well,
+// at most once per -collector.networks.cache-ttl.
+type networkCollector struct {
+	dockerClient *client.Client
+	limiter      *rate.Limiter
+
+	mu    sync.Mutex
+	cache map[string]cachedNetworkCount
+}
+
+func newNetworkCollector(cli *client.Client, limiter *rate.Limiter) *networkCollector {
+	return &networkCollector{
+		dockerClient: cli,
+		limiter:      limiter,
+		cache:        map[string]cachedNetworkCount{},
+	}
+}
+
+func (nc *networkCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- networkInfoDesc
+	ch <- networkContainersDesc
+}
+
+func (nc *networkCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), networksTimeout)
+	defer cancel()
+
+	if err := nc.limiter.Wait(ctx); err != nil {
+		log.Println("Failed to list networks:", err)
+		return
+	}
+	networks, err := nc.dockerClient.NetworkList(ctx, types.NetworkListOptions{})
+	if err != nil {
+		log.Println("Failed to list networks:", err)
+		return
+	}
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	seen := make(map[string]bool, len(networks))
+	for _, network := range networks {
+		seen[network.ID] = true
+		ch <- prometheus.MustNewConstMetric(networkInfoDesc, prometheus.GaugeValue, 1, network.ID, network.Name, network.Driver, network.Scope)
+
+		count, err := nc.containerCount(ctx, network.ID)
+		if err != nil {
+			log.Println("Failed to inspect network", network.ID, ":", err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(networkContainersDesc, prometheus.GaugeValue, float64(count), network.ID, network.Name)
+	}
+
+	for id := range nc.cache {
+		if !seen[id] {
+			delete(nc.cache, id)
+		}
+	}
+}
+
+// containerCount returns the number of containers connected to a network,
+// from the cache when it is fresh enough. The caller must hold nc.mu.
+func (nc *networkCollector) containerCount(ctx context.Context, networkID string) (int, error) {
+	if cached, ok := nc.cache[networkID]; ok && time.Since(cached.inspectedAt) < *networksCacheTTL {
+		return cached.containers, nil
+	}
+
+	if err := nc.limiter.Wait(ctx); err != nil {
+		return 0, err
+	}
+	network, err := nc.dockerClient.NetworkInspect(ctx, networkID, types.NetworkInspectOptions{})
+	if err != nil {
+		return 0, err
+	}
+
+	nc.cache[networkID] = cachedNetworkCount{
+		containers:  len(network.Containers),
+		inspectedAt: time.Now(),
+	}
+	return len(network.Containers), nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"log"
+	"time"
+)
+
+var (
+	pingInterval = flag.Duration("docker.ping-interval", 0, "Ping the Docker daemon in the background at this interval and export the result as docker_reachable. 0 disables it.")
+)
+
+// daemonPinger checks the reachability of the Docker daemon independently of
+// scrapes.
+type daemonPinger struct {
+	dockerClient *client.Client
+	limiter      *rate.Limiter
+	reachable    prometheus.Gauge
+}
+
+// newDaemonPinger creates a daemonPinger and registers its gauge with reg.
+func newDaemonPinger(cli *client.Client, limiter *rate.Limiter, reg prometheus.Registerer) *daemonPinger {
+	p := &daemonPinger{
+		dockerClient: cli,
+		limiter:      limiter,
+		reachable: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "docker_reachable",
+			Help:      "Whether the last background ping of the Docker daemon succeeded (1) or not (0)",
+		}),
+	}
+	reg.MustRegister(p.reachable)
+	return p
+}
+
+// start pings the daemon once and then every interval. Each ping has to
+// finish within the interval, so pings never pile up.
+func (p *daemonPinger) start(interval time.Duration) {
+	reachable := p.ping(interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			now := p.ping(interval)
+			if now != reachable {
+				if now {
+					log.Println("Docker daemon", p.dockerClient.DaemonHost(), "is reachable again")
+				} else {
+					log.Println("Docker daemon", p.dockerClient.DaemonHost(), "became unreachable")
+				}
+			}
+			reachable = now
+		}
+	}()
+}
+
+func (p *daemonPinger) ping(timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := p.limiter.Wait(ctx)
+	if err == nil {
+		_, err = p.dockerClient.Ping(ctx)
+	}
+	if err != nil {
+		logDebug("Failed to ping Docker daemon:", err)
+		p.reachable.Set(0)
+		return false
+	}
+	p.reachable.Set(1)
+	return true
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	collectPSI = flag.Bool("collector.psi", false, "Export pressure stall information (PSI) of running containers, read from their cgroup v2 pressure files.")
+	cgroupRoot = flag.String("collector.psi.cgroup-root", "/sys/fs/cgroup", "Mount point of the host's cgroup v2 hierarchy.")
+	procRoot   = flag.String("collector.psi.proc-root", "/proc", "Mount point of the host's procfs, used to resolve container cgroups.")
+)
+
+// psiResources are the cgroup controllers that expose a <resource>.pressure file.
+var psiResources = []string{"cpu", "io", "memory"}
+
+// pressureStats is one line of a PSI file, e.g.
+//
+//	some avg10=0.12 avg60=0.05 avg300=0.01 total=123456
+type pressureStats struct {
+	kind string // "some" or "full"
+	// avg10 is the share of the last 10 seconds spent stalled, from 0 to 1.
+	avg10 float64
+	// totalSeconds is the cumulative stall time.
+	totalSeconds float64
+}
+
+// readContainerPressure returns the PSI of a container, keyed by resource.
+// Hosts without cgroup v2 or with PSI disabled have no pressure files, which
+// results in an empty map rather than an error.
+func readContainerPressure(containerID string, pid int) map[string][]pressureStats {
+	dir := containerCgroupDir(containerID, pid)
+	if dir == "" {
+		return nil
+	}
+
+	pressure := map[string][]pressureStats{}
+	for _, resource := range psiResources {
+		stats, err := readPressureFile(filepath.Join(dir, resource+".pressure"))
+		if err != nil {
+			continue
+		}
+		pressure[resource] = stats
+	}
+	return pressure
+}
+
+// containerCgroupDir resolves the cgroup v2 directory of a container, first
+// from the cgroup of its init process, then from the paths used by the
+// systemd and cgroupfs drivers.
+func containerCgroupDir(containerID string, pid int) string {
+	candidates := []string{}
+	if pid > 0 {
+		if path, err := unifiedCgroupPath(filepath.Join(*procRoot, strconv.Itoa(pid), "cgroup")); err == nil {
+			candidates = append(candidates, filepath.Join(*cgroupRoot, path))
+		}
+	}
+	candidates = append(candidates,
+		filepath.Join(*cgroupRoot, "system.slice", "docker-"+containerID+".scope"),
+		filepath.Join(*cgroupRoot, "docker", containerID),
+	)
+
+	for _, dir := range candidates {
+		if _, err := os.Stat(filepath.Join(dir, "cgroup.procs")); err == nil {
+			return dir
+		}
+	}
+	return ""
+}
+
+// unifiedCgroupPath returns the cgroup v2 path from a /proc/<pid>/cgroup file.
+func unifiedCgroupPath(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no cgroup v2 entry in %s", file)
+}
+
+func readPressureFile(file string) ([]pressureStats, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var stats []pressureStats
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		line := pressureStats{kind: fields[0]}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", file, err)
+			}
+			switch key {
+			case "avg10":
+				line.avg10 = v / 100
+			case "total":
+				// Reported in microseconds
+				line.totalSeconds = v / 1e6
+			}
+		}
+		stats = append(stats, line)
+	}
+	return stats, scanner.Err()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"log"
+	"time"
+)
+
+var (
+	pushGatewayURL = flag.String("push.gateway-url", "", "Pushgateway URL. When set, metrics are pushed instead of served over HTTP.")
+	pushJob        = flag.String("push.job", "docker_exporter", "Job name used when pushing to the Pushgateway.")
+	pushInterval   = flag.Duration("push.interval", 0, "Interval between pushes. 0 pushes once and exits.")
+	pushRetries    = flag.Int("push.retries", 3, "Number of retries for a failed push before giving up.")
+)
+
+// runPush gathers from g and pushes the result to the configured Pushgateway,
+// either once or every -push.interval. It exits the process on a push that
+// still fails after all retries.
+func runPush(g prometheus.Gatherer) {
+	pusher := push.New(*pushGatewayURL, *pushJob).Gatherer(g)
+
+	for {
+		if err := pushWithRetries(pusher); err != nil {
+			log.Fatal("Error pushing to Pushgateway:", err)
+		}
+		if *pushInterval <= 0 {
+			return
+		}
+		time.Sleep(*pushInterval)
+	}
+}
+
+func pushWithRetries(pusher *push.Pusher) error {
+	backoff := time.Second
+
+	var err error
+	for attempt := 0; attempt <= *pushRetries; attempt++ {
+		if attempt > 0 {
+			log.Println("Push to Pushgateway failed, retrying in", backoff, ":", err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = pusher.Push(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"sync"
+	"time"
+)
+
+// snapshotGatherer serves the result of the last background gather, so that
+// scrapes never wait on the Docker API.
+type snapshotGatherer struct {
+	gatherer    prometheus.Gatherer
+	lastRefresh prometheus.Gauge
+
+	mu       sync.RWMutex
+	families []*dto.MetricFamily
+	err      error
+}
+
+// newSnapshotGatherer creates a snapshotGatherer for g. The refresh timestamp
+// is registered with reg, which must not be gathered by g.
+func newSnapshotGatherer(g prometheus.Gatherer, reg prometheus.Registerer) *snapshotGatherer {
+	s := &snapshotGatherer{
+		gatherer: g,
+		lastRefresh: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_refresh_timestamp_seconds",
+			Help:      "Unix time of the last completed background refresh of the metrics snapshot",
+		}),
+	}
+	reg.MustRegister(s.lastRefresh)
+	return s
+}
+
+// start takes a first snapshot and then refreshes it every interval.
+func (s *snapshotGatherer) start(interval time.Duration) {
+	s.refresh()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.refresh()
+		}
+	}()
+}
+
+func (s *snapshotGatherer) refresh() {
+	families, err := s.gatherer.Gather()
+
+	s.mu.Lock()
+	s.families, s.err = families, err
+	s.mu.Unlock()
+
+	s.lastRefresh.SetToCurrentTime()
+}
+
+func (s *snapshotGatherer) Gather() ([]*dto.MetricFamily, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.families, s.err
+}
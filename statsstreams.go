@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	collectStatsStreams = flag.Bool("collector.stats-streams", false, "Keep a streaming stats connection open to every running container and serve its stats from the latest sample, instead of requesting them on every scrape.")
+)
+
+// statsStream is the open stats stream of a container.
+type statsStream struct {
+	cancel context.CancelFunc
+
+	// metrics is nil until the first sample arrived.
+	metrics   *containerMetrics
+	sampledAt time.Time
+}
+
+// statsStreams keeps the latest stats sample of every running container a
+// collector selects. Streams are opened and closed as containers start and
+// stop, from the container events and from the container list of every
+// collection, which also covers events missed while the events stream
+// reconnects.
+type statsStreams struct {
+	dc *dockerCollector
+
+	mu      sync.Mutex
+	streams map[string]*statsStream
+	// containers holds the containers of the last list, updated by the
+	// events since, so that a config reload can select them again.
+	containers map[string]types.Container
+}
+
+func newStatsStreams(dc *dockerCollector) *statsStreams {
+	return &statsStreams{
+		dc:         dc,
+		streams:    map[string]*statsStream{},
+		containers: map[string]types.Container{},
+	}
+}
+
+// start opens the streams of the running containers and follows the
+// container events.
+func (ss *statsStreams) start() {
+	containers, err := ss.dc.listContainers(context.Background())
+	if err != nil {
+		log.Println("Failed to list containers for stats streams:", err)
+	} else {
+		ss.dc.mu.RLock()
+		ss.sync(containers)
+		ss.dc.mu.RUnlock()
+	}
+
+	stream := &eventStream{
+		dockerClient: ss.dc.dockerClient,
+		limiter:      ss.dc.limiter,
+		filters: filters.NewArgs(
+			filters.Arg("type", string(events.ContainerEventType)),
+			filters.Arg("event", "start"),
+			filters.Arg("event", "die"),
+		),
+		handle: ss.handle,
+	}
+	stream.start()
+}
+
+func (ss *statsStreams) handle(msg events.Message) {
+	ss.dc.mu.RLock()
+	defer ss.dc.mu.RUnlock()
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	container, ok := ss.containers[msg.Actor.ID]
+	switch msg.Action {
+	case "start":
+		if !ok {
+			container = eventContainer(msg)
+		}
+		container.State = "running"
+	case "die":
+		if !ok {
+			return
+		}
+		container.State = "exited"
+	}
+	ss.containers[msg.Actor.ID] = container
+	ss.apply()
+}
+
+// eventContainer returns what the container list would have for the container
+// an event is about. The events carry the container's name, image and labels
+// as attributes, but not its creation time, for which the time of the event
+// stands in until the next list.
+func eventContainer(msg events.Message) types.Container {
+	labels := make(map[string]string, len(msg.Actor.Attributes))
+	for key, value := range msg.Actor.Attributes {
+		if key != "name" && key != "image" {
+			labels[key] = value
+		}
+	}
+	return types.Container{
+		ID:      msg.Actor.ID,
+		Names:   []string{"/" + msg.Actor.Attributes["name"]},
+		Image:   msg.Actor.Attributes["image"],
+		Labels:  labels,
+		Created: msg.Time,
+	}
+}
+
+// sync replaces the known containers with a new list and applies it. The
+// caller must hold ss.dc.mu for reading.
+func (ss *statsStreams) sync(containers []types.Container) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.containers = make(map[string]types.Container, len(containers))
+	for _, container := range containers {
+		ss.containers[container.ID] = container
+	}
+	ss.apply()
+}
+
+// reselect applies the known containers again after the collector's filters
+// changed. The caller must hold ss.dc.mu.
+func (ss *statsStreams) reselect() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.apply()
+}
+
+// apply opens the streams of the running containers the collector selects
+// that don't have one, and closes all others. The caller must hold ss.mu and
+// ss.dc.mu.
+func (ss *statsStreams) apply() {
+	selected := make(map[string]bool, len(ss.containers))
+	for id, container := range ss.containers {
+		if container.State == "running" && selectContainer(container, ss.dc.imageRegexp, ss.dc.filter) {
+			selected[id] = true
+			ss.open(id)
+		}
+	}
+	for id := range ss.streams {
+		if !selected[id] {
+			ss.close(id)
+		}
+	}
+}
+
+// latest returns the last stats sample of a container and when it was taken,
+// or ok false if it has none yet.
+func (ss *statsStreams) latest(containerID string) (metrics *containerMetrics, sampledAt time.Time, ok bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	stream, ok := ss.streams[containerID]
+	if !ok || stream.metrics == nil {
+		return nil, time.Time{}, false
+	}
+	return stream.metrics, stream.sampledAt, true
+}
+
+// open starts the stream of a container unless it has one. The caller must
+// hold ss.mu.
+func (ss *statsStreams) open(containerID string) {
+	if _, ok := ss.streams[containerID]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &statsStream{cancel: cancel}
+	ss.streams[containerID] = stream
+	go ss.follow(ctx, containerID, stream)
+}
+
+// close stops the stream of a container. The caller must hold ss.mu.
+func (ss *statsStreams) close(containerID string) {
+	if stream, ok := ss.streams[containerID]; ok {
+		stream.cancel()
+		delete(ss.streams, containerID)
+	}
+}
+
+// follow reads the stats stream of a container until it ends, and then
+// forgets the stream so that the next sync or start event can reopen it.
+func (ss *statsStreams) follow(ctx context.Context, containerID string, stream *statsStream) {
+	err := ss.read(ctx, containerID, stream)
+	if ctx.Err() != nil {
+		return
+	}
+	logDebug("Stats stream of container", containerID, "ended:", err)
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.streams[containerID] == stream {
+		ss.close(containerID)
+	}
+}
+
+func (ss *statsStreams) read(ctx context.Context, containerID string, stream *statsStream) error {
+	if err := ss.dc.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	stats, err := ss.dc.dockerClient.ContainerStats(ctx, containerID, true)
+	if err != nil {
+		return err
+	}
+	defer stats.Body.Close()
+
+	// The size limit applies to every sample rather than the whole stream.
+	body := &maxBytesReader{r: stats.Body}
+	decoder := json.NewDecoder(body)
+	for {
+		body.n = *maxStatsBytes
+		var statData types.StatsJSON
+		if err := decoder.Decode(&statData); err != nil {
+			return err
+		}
+		// The first sample has no previous reading to compute the CPU
+		// usage from.
+		if !hasPreviousReading(&statData, stats.OSType) {
+			continue
+		}
+
+		metrics, err := newContainerMetrics(&statData, stats.OSType, cpuPercent(&statData, stats.OSType))
+		if err != nil {
+			continue
+		}
+
+		ss.mu.Lock()
+		stream.metrics, stream.sampledAt = metrics, statData.Read
+		ss.mu.Unlock()
+	}
+}
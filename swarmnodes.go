@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"log"
+	"time"
+)
+
+// swarmTimeout bounds the swarm API calls of a scrape or of the startup check,
+// so that an unresponsive manager doesn't hang them.
+const swarmTimeout = 10 * time.Second
+
+var (
+	collectSwarmNodes = flag.Bool("collector.swarm-nodes", false, "Export the status of the nodes of the swarm. Only works on swarm managers.")
+)
+
+// swarmNodeCollector exports the nodes of the swarm the daemon manages.
+type swarmNodeCollector struct {
+	dockerClient *client.Client
+	limiter      *rate.Limiter
+}
+
+func newSwarmNodeCollector(cli *client.Client, limiter *rate.Limiter) *swarmNodeCollector {
+	return &swarmNodeCollector{
+		dockerClient: cli,
+		limiter:      limiter,
+	}
+}
+
+// usable lists the nodes once, to find out whether the daemon is a swarm
+// manager. It only reports false for errors that won't go away by retrying.
+func (snc *swarmNodeCollector) usable() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), swarmTimeout)
+	defer cancel()
+
+	_, err := snc.listNodes(ctx)
+	if errdefs.IsUnavailable(err) || errdefs.IsForbidden(err) {
+		log.Println("Disabling the swarm node collector, the Docker daemon is not a reachable swarm manager:", err)
+		return false
+	}
+	return true
+}
+
+func (snc *swarmNodeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- swarmNodeInfoDesc
+	ch <- swarmNodeReadyDesc
+}
+
+func (snc *swarmNodeCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), swarmTimeout)
+	defer cancel()
+
+	nodes, err := snc.listNodes(ctx)
+	if err != nil {
+		log.Println("Failed to list swarm nodes:", err)
+		return
+	}
+
+	for _, node := range nodes {
+		ch <- prometheus.MustNewConstMetric(swarmNodeInfoDesc, prometheus.GaugeValue, 1,
+			node.ID, node.Description.Hostname, string(node.Spec.Role), string(node.Spec.Availability))
+
+		ready := 0.0
+		if node.Status.State == swarm.NodeStateReady {
+			ready = 1
+		}
+		ch <- prometheus.MustNewConstMetric(swarmNodeReadyDesc, prometheus.GaugeValue, ready, node.ID)
+	}
+}
+
+func (snc *swarmNodeCollector) listNodes(ctx context.Context) ([]swarm.Node, error) {
+	if err := snc.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return snc.dockerClient.NodeList(ctx, types.NodeListOptions{})
+}
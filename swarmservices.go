@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"log"
+	"sort"
+)
+
+var (
+	collectSwarmServices = flag.Bool("collector.swarm-services", true, "Export the replicas and tasks of the swarm services when the daemon is a swarm manager.")
+)
+
+// swarmServiceCollector exports the services of the swarm the daemon manages
+// and the states of their tasks.
+type swarmServiceCollector struct {
+	dockerClient *client.Client
+	limiter      *rate.Limiter
+}
+
+func newSwarmServiceCollector(cli *client.Client, limiter *rate.Limiter) *swarmServiceCollector {
+	return &swarmServiceCollector{
+		dockerClient: cli,
+		limiter:      limiter,
+	}
+}
+
+// usable lists the services once, to find out whether the daemon is a swarm
+// manager. The collector is on by default, so not being one is no surprise
+// and only logged at debug level.
+func (ssc *swarmServiceCollector) usable() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), swarmTimeout)
+	defer cancel()
+
+	_, err := ssc.listServices(ctx)
+	if errdefs.IsUnavailable(err) || errdefs.IsForbidden(err) {
+		logDebug("Disabling the swarm service collector, the Docker daemon is not a reachable swarm manager:", err)
+		return false
+	}
+	return true
+}
+
+func (ssc *swarmServiceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- swarmServiceDesiredReplicasDesc
+	ch <- swarmServiceRunningReplicasDesc
+	ch <- swarmServiceTasksDesc
+}
+
+func (ssc *swarmServiceCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), swarmTimeout)
+	defer cancel()
+
+	services, err := ssc.listServices(ctx)
+	if errdefs.IsUnavailable(err) {
+		// The daemon was unreachable at startup, or left the swarm since.
+		logDebug("Failed to list swarm services:", err)
+		return
+	}
+	if err != nil {
+		log.Println("Failed to list swarm services:", err)
+		return
+	}
+	tasks, err := ssc.listTasks(ctx)
+	if err != nil {
+		log.Println("Failed to list swarm tasks:", err)
+		return
+	}
+
+	// Tasks are kept for a while after they ended, so the failed and
+	// shutdown ones include the recent history of each service.
+	states := map[string]map[swarm.TaskState]int{}
+	for _, task := range tasks {
+		if states[task.ServiceID] == nil {
+			states[task.ServiceID] = map[swarm.TaskState]int{}
+		}
+		states[task.ServiceID][task.Status.State]++
+	}
+
+	for _, service := range services {
+		name := service.Spec.Name
+		if status := service.ServiceStatus; status != nil {
+			ch <- prometheus.MustNewConstMetric(swarmServiceDesiredReplicasDesc, prometheus.GaugeValue, float64(status.DesiredTasks), service.ID, name)
+			ch <- prometheus.MustNewConstMetric(swarmServiceRunningReplicasDesc, prometheus.GaugeValue, float64(status.RunningTasks), service.ID, name)
+		}
+
+		serviceStates := make([]swarm.TaskState, 0, len(states[service.ID]))
+		for state := range states[service.ID] {
+			serviceStates = append(serviceStates, state)
+		}
+		sort.Slice(serviceStates, func(i, j int) bool {
+			return serviceStates[i] < serviceStates[j]
+		})
+		for _, state := range serviceStates {
+			count := states[service.ID][state]
+			ch <- prometheus.MustNewConstMetric(swarmServiceTasksDesc, prometheus.GaugeValue, float64(count), service.ID, name, string(state))
+		}
+	}
+}
+
+func (ssc *swarmServiceCollector) listServices(ctx context.Context) ([]swarm.Service, error) {
+	if err := ssc.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return ssc.dockerClient.ServiceList(ctx, types.ServiceListOptions{Status: true})
+}
+
+func (ssc *swarmServiceCollector) listTasks(ctx context.Context) ([]swarm.Task, error) {
+	if err := ssc.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return ssc.dockerClient.TaskList(ctx, types.TaskListOptions{})
+}
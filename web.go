@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	kitlog "github.com/go-kit/log"
+	"github.com/prometheus/exporter-toolkit/web"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultListenAddress = ":924"
+
+	// shutdownTimeout bounds how long in-flight requests get to finish.
+	shutdownTimeout = 10 * time.Second
+
+	// healthzTimeout bounds the daemon pings of a /healthz request.
+	healthzTimeout = 5 * time.Second
+)
+
+// listenAddresses holds every -web.listen-address given.
+var listenAddresses = stringsFlag{}
+
+var (
+	telemetryPath = flag.String("web.telemetry-path", "/metrics", "Path under which to expose the metrics.")
+	webConfigFile = flag.String("web.config.file", "", "Path to a Prometheus web.config.file that enables TLS or basic authentication.")
+)
+
+// serve runs an HTTP server for handler on every address until one of them
+// fails or the process is asked to terminate, then shuts them all down. TLS
+// and basic authentication are set up from -web.config.file.
+func serve(handler http.Handler, addresses []string) error {
+	if len(addresses) == 0 {
+		addresses = []string{defaultListenAddress}
+	}
+
+	server := &http.Server{Handler: handler}
+	flags := &web.FlagConfig{
+		WebListenAddresses: &addresses,
+		WebConfigFile:      webConfigFile,
+	}
+	logger := kitlog.NewLogfmtLogger(stdLogWriter{})
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- web.ListenAndServe(server, flags, logger)
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	var err error
+	select {
+	case err = <-errs:
+	case sig := <-stop:
+		log.Println("Received", sig, "shutting down")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if shutdownErr := server.Shutdown(ctx); shutdownErr != nil {
+		log.Println("Error shutting down:", shutdownErr)
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// stdLogWriter writes the log lines of the exporter toolkit to the standard
+// logger, so that they get the same timestamps as the exporter's own.
+type stdLogWriter struct{}
+
+func (stdLogWriter) Write(p []byte) (int, error) {
+	log.Print(string(p))
+	return len(p), nil
+}
+
+// landingPage returns the handler of the page listing the exporter's
+// endpoints, which is only served on / itself.
+func landingPage() (http.Handler, error) {
+	links := []web.LandingLinks{
+		{Address: *telemetryPath, Text: "Metrics"},
+		{Address: "/healthz", Text: "Health", Description: "Whether every Docker daemon responds to a ping"},
+	}
+	if *enableDebug {
+		links = append(links, web.LandingLinks{Address: "/containers", Text: "Containers", Description: "Inventory of the collected containers"})
+	}
+	page, err := web.NewLandingPage(web.LandingConfig{
+		Name:        "Docker Exporter",
+		Description: "Prometheus exporter for the metrics of Docker containers",
+		Version:     version,
+		Links:       links,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		page.ServeHTTP(w, r)
+	}), nil
+}
+
+// healthzHandler serves /healthz, which pings every Docker daemon and fails
+// with 503 Service Unavailable unless all of them respond.
+func healthzHandler(dcs []*dockerCollector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), healthzTimeout)
+		defer cancel()
+
+		var failed []string
+		for _, dc := range dcs {
+			err := dc.limiter.Wait(ctx)
+			if err == nil {
+				_, err = dc.dockerClient.Ping(ctx)
+			}
+			if err != nil {
+				logDebug("Health check of Docker daemon", dc.dockerClient.DaemonHost(), "failed:", err)
+				failed = append(failed, dc.dockerClient.DaemonHost())
+			}
+		}
+
+		if len(failed) > 0 {
+			http.Error(w, fmt.Sprintf("Docker daemon not reachable: %s", strings.Join(failed, ", ")), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "OK")
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/prometheus/client_golang/prometheus"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	collectWindowedStats = flag.Bool("collector.windowed-stats", false, "Sample the CPU and memory usage of running containers in the background and export their average, maximum and minimum over -metrics.window.")
+	metricsWindow        = flag.Duration("metrics.window", time.Minute, "Window of the -collector.windowed-stats aggregates.")
+	windowedInterval     = flag.Duration("collector.windowed-stats.interval", 5*time.Second, "Interval of the -collector.windowed-stats background sampler.")
+)
+
+// windowStats are the aggregates exported for windowed samples.
+var windowStats = []string{"avg", "max", "min"}
+
+// usageSample is a CPU and memory reading of a container.
+type usageSample struct {
+	at     time.Time
+	cpu    float64
+	memory float64
+}
+
+// sampleRing keeps the last samples of a container, overwriting the oldest.
+type sampleRing struct {
+	samples []usageSample
+	next    int
+}
+
+func (r *sampleRing) add(s usageSample, size int) {
+	if len(r.samples) < size {
+		r.samples = append(r.samples, s)
+		return
+	}
+	r.samples[r.next] = s
+	r.next = (r.next + 1) % len(r.samples)
+}
+
+// aggregate returns the avg, max and min of the CPU and memory samples taken
+// after since, or ok false if there are none.
+func (r *sampleRing) aggregate(since time.Time) (cpu, memory map[string]float64, ok bool) {
+	var n int
+	cpu = map[string]float64{}
+	memory = map[string]float64{}
+	for _, s := range r.samples {
+		if s.at.Before(since) {
+			continue
+		}
+		if n == 0 || s.cpu > cpu["max"] {
+			cpu["max"] = s.cpu
+		}
+		if n == 0 || s.cpu < cpu["min"] {
+			cpu["min"] = s.cpu
+		}
+		if n == 0 || s.memory > memory["max"] {
+			memory["max"] = s.memory
+		}
+		if n == 0 || s.memory < memory["min"] {
+			memory["min"] = s.memory
+		}
+		cpu["avg"] += s.cpu
+		memory["avg"] += s.memory
+		n++
+	}
+	if n == 0 {
+		return nil, nil, false
+	}
+	cpu["avg"] /= float64(n)
+	memory["avg"] /= float64(n)
+	return cpu, memory, true
+}
+
+// windowedStats samples the usage of the running containers of a collector
+// in the background. At most window/interval samples are kept per container,
+// which bounds the memory it needs.
+type windowedStats struct {
+	dc *dockerCollector
+
+	mu    sync.Mutex
+	rings map[string]*sampleRing
+}
+
+func newWindowedStats(dc *dockerCollector) *windowedStats {
+	return &windowedStats{
+		dc:    dc,
+		rings: map[string]*sampleRing{},
+	}
+}
+
+// start runs the sampler every -collector.windowed-stats.interval.
+func (ws *windowedStats) start() {
+	go func() {
+		ticker := time.NewTicker(*windowedInterval)
+		defer ticker.Stop()
+		for {
+			ws.sample()
+			<-ticker.C
+		}
+	}()
+}
+
+func (ws *windowedStats) sample() {
+	// A config reload may change the settings while sampling, so they are
+	// read once under the collector's lock.
+	ws.dc.mu.RLock()
+	timeout := *perContainerTimeout
+	imageRe, filter := ws.dc.imageRegexp, ws.dc.filter
+	ws.dc.mu.RUnlock()
+
+	ctx := context.Background()
+	containers, err := ws.dc.listContainers(ctx)
+	if err != nil {
+		log.Println("Failed to list containers for windowed stats:", err)
+		return
+	}
+
+	size := int(*metricsWindow / *windowedInterval)
+	if size < 1 {
+		size = 1
+	}
+
+	listed := make(map[string]bool, len(containers))
+	samples := map[string]usageSample{}
+	for _, container := range containers {
+		listed[container.ID] = true
+		if container.State != "running" || !selectContainer(container, imageRe, filter) {
+			continue
+		}
+		statsCtx, cancel := context.WithTimeout(ctx, timeout)
+		metrics, err := ws.dc.getContainerMetrics(statsCtx, container.ID)
+		cancel()
+		if err != nil {
+			logDebug("Failed to sample container", container.ID, ":", err)
+			continue
+		}
+		samples[container.ID] = usageSample{
+			at:     time.Now(),
+			cpu:    metrics.cpuUsagePercent,
+			memory: float64(metrics.memoryUsageBytes),
+		}
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for id, s := range samples {
+		ring, ok := ws.rings[id]
+		if !ok {
+			ring = &sampleRing{}
+			ws.rings[id] = ring
+		}
+		ring.add(s, size)
+	}
+	for id := range ws.rings {
+		if !listed[id] {
+			delete(ws.rings, id)
+		}
+	}
+}
+
+// emit sends the windowed aggregates of a container, if it has samples.
+func (ws *windowedStats) emit(ch chan<- prometheus.Metric, containerID string, labels []string) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ring, ok := ws.rings[containerID]
+	if !ok {
+		return
+	}
+	cpu, memory, ok := ring.aggregate(time.Now().Add(-*metricsWindow))
+	if !ok {
+		return
+	}
+	for _, stat := range windowStats {
+		ch <- prometheus.MustNewConstMetric(windowCPUUsageDescs[stat], prometheus.GaugeValue, cpu[stat], labels...)
+		ch <- prometheus.MustNewConstMetric(windowMemoryUsageDescs[stat], prometheus.GaugeValue, memory[stat], labels...)
+	}
+}